@@ -0,0 +1,305 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// ACLSettings configures the acl scraper, zero values use the defaults.
+type ACLSettings struct {
+	// LogEntries is the number of ACL LOG entries read on each scrape, it
+	// should match acllog-max-len. Defaults to 128.
+	LogEntries int
+}
+
+var (
+	aclLogEventsTotal = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "acl", "log_events_total"),
+		"Number of events recorded in the ACL LOG since the exporter started, by client ip.",
+		[]string{"addr", "reason", "username", "ip"},
+		nil,
+	)
+	aclUsers = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "acl", "users"),
+		"Number of ACL users.",
+		[]string{"addr"},
+		nil,
+	)
+	aclUserNopass = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "acl", "user_nopass"),
+		"Whether the ACL user can authenticate with any password.",
+		[]string{"addr", "user"},
+		nil,
+	)
+	aclUserAllCommands = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "acl", "user_all_commands"),
+		"Whether the ACL user is allowed to run all commands.",
+		[]string{"addr", "user"},
+		nil,
+	)
+	aclUserEnabled = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "acl", "user_enabled"),
+		"Whether the ACL user is enabled.",
+		[]string{"addr", "user"},
+		nil,
+	)
+)
+
+// aclLogEntryKey identifies an ACL LOG entry across scrapes. Entries have an id
+// since redis 7.2, older ones are identified by their content.
+type aclLogEntryKey struct {
+	entryID  string
+	reason   string
+	context  string
+	object   string
+	username string
+	client   string
+}
+
+// aclLogCounterKey identifies an ACL LOG counter. Clients are identified by
+// their ip only, their port changes with every connection.
+type aclLogCounterKey struct {
+	reason   string
+	username string
+	ip       string
+}
+
+// aclLogState is what is remembered about the ACL LOG of a node between scrapes.
+type aclLogState struct {
+	// counts is the count of every entry at the previous scrape, nil before
+	// the first one.
+	counts map[aclLogEntryKey]int64
+	// totals are the counters exported by the scraper.
+	totals map[aclLogCounterKey]float64
+}
+
+type aclUser struct {
+	name        string
+	enabled     bool
+	nopass      bool
+	allCommands bool
+}
+
+type aclScraper struct {
+	logEntries int
+
+	mu    sync.Mutex
+	state map[string]*aclLogState
+}
+
+func NewACLScraper(settings ACLSettings) *aclScraper {
+	return &aclScraper{
+		logEntries: intSetting(settings.LogEntries, 128),
+		state:      make(map[string]*aclLogState),
+	}
+}
+
+// parseACLList parses the ACL LIST reply, one `user <name> <rules>...` line per user.
+func parseACLList(lines []string) []*aclUser {
+	users := make([]*aclUser, 0, len(lines))
+	for _, line := range lines {
+		tokens := strings.Fields(line)
+		if len(tokens) < 2 || tokens[0] != "user" {
+			continue
+		}
+		user := &aclUser{name: tokens[1]}
+		for _, token := range tokens[2:] {
+			switch token {
+			case "on":
+				user.enabled = true
+			case "off":
+				user.enabled = false
+			case "nopass":
+				user.nopass = true
+			case "+@all", "allcommands":
+				user.allCommands = true
+			case "-@all", "nocommands":
+				user.allCommands = false
+			}
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// updateACLLog adds the events of the ACL LOG entries seen since the previous
+// scrape to the counters. Entries are merged by redis while the same event repeats,
+// so only the growth of their count is new. The entries found by the first
+// scrape happened before the exporter started, their counters start at 0.
+func updateACLLog(state *aclLogState, entries []map[string]interface{}) {
+	first := state.counts == nil
+	counts := make(map[aclLogEntryKey]int64, len(entries))
+	for _, entry := range entries {
+		var client string
+		if clients := parseClientListResp(fmt.Sprint(entry["client-info"])); len(clients) > 0 {
+			client = clientIP(clients[0]["addr"])
+		}
+
+		key := aclLogEntryKey{
+			reason:   fmt.Sprint(entry["reason"]),
+			context:  fmt.Sprint(entry["context"]),
+			object:   fmt.Sprint(entry["object"]),
+			username: fmt.Sprint(entry["username"]),
+			client:   client,
+		}
+		if id, ok := entry["entry-id"]; ok {
+			key.entryID = fmt.Sprint(id)
+		}
+
+		count, ok := redisReplyToFloat64(entry["count"])
+		if !ok {
+			continue
+		}
+		counts[key] = int64(count)
+
+		counterKey := aclLogCounterKey{reason: key.reason, username: key.username, ip: key.client}
+		if first {
+			if _, ok := state.totals[counterKey]; !ok {
+				state.totals[counterKey] = 0
+			}
+			continue
+		}
+		delta := int64(count)
+		if prev, ok := state.counts[key]; ok && prev <= delta {
+			delta -= prev
+		}
+		if delta > 0 {
+			state.totals[counterKey] += float64(delta)
+		}
+	}
+	state.counts = counts
+}
+
+// Scrape implements Scraper.
+func (scraper *aclScraper) Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error {
+	var err error
+
+	for _, rdb := range sc.Clients {
+		addr := rdb.Options().Addr
+
+		var res interface{}
+		res, err = rdb.Do(ctx, "ACL", "LOG", scraper.logEntries).Result()
+		if err != nil {
+			return err
+		}
+		replies, _ := res.([]interface{})
+		entries := make([]map[string]interface{}, 0, len(replies))
+		for _, reply := range replies {
+			entries = append(entries, parseRedisMapReply(reply))
+		}
+
+		var lines []string
+		lines, err = rdb.Do(ctx, "ACL", "LIST").StringSlice()
+		if err != nil {
+			return err
+		}
+		users := parseACLList(lines)
+
+		scraper.mu.Lock()
+		state, ok := scraper.state[addr]
+		if !ok {
+			state = &aclLogState{totals: make(map[aclLogCounterKey]float64)}
+			scraper.state[addr] = state
+		}
+		updateACLLog(state, entries)
+		totals := make(map[aclLogCounterKey]float64, len(state.totals))
+		for k, v := range state.totals {
+			totals[k] = v
+		}
+		scraper.mu.Unlock()
+
+		for k, v := range totals {
+			ch <- prometheus.MustNewConstMetric(aclLogEventsTotal, prometheus.CounterValue, v, addr, k.reason, k.username, k.ip)
+		}
+
+		ch <- prometheus.MustNewConstMetric(aclUsers, prometheus.GaugeValue, float64(len(users)), addr)
+		for _, user := range users {
+			ch <- prometheus.MustNewConstMetric(aclUserEnabled, prometheus.GaugeValue, boolToFloat64(user.enabled), addr, user.name)
+			ch <- prometheus.MustNewConstMetric(aclUserNopass, prometheus.GaugeValue, boolToFloat64(user.nopass), addr, user.name)
+			ch <- prometheus.MustNewConstMetric(aclUserAllCommands, prometheus.GaugeValue, boolToFloat64(user.allCommands), addr, user.name)
+		}
+	}
+
+	return err
+}
+
+// Forget implements NodeStateScraper.
+func (scraper *aclScraper) Forget(addr string) {
+	scraper.mu.Lock()
+	defer scraper.mu.Unlock()
+	delete(scraper.state, addr)
+}
+
+// Help implements Scraper.
+func (*aclScraper) Help() string {
+	return "Collect ACL LOG events and ACL users from each redis node."
+}
+
+// Name implements Scraper.
+func (*aclScraper) Name() string {
+	return "acl"
+}
+
+// Version implements Scraper.
+func (*aclScraper) Version() string {
+	return "6.0"
+}
+
+// Commands implements CommandsScraper.
+func (*aclScraper) Commands() [][]interface{} {
+	return [][]interface{}{
+		{"acl", "log"},
+		{"acl", "list"},
+	}
+}
+
+var _ CommandsScraper = &aclScraper{}
+var _ NodeStateScraper = &aclScraper{}
+
+// aclCheckKey is the key name used to dry-run commands which take a key.
+const aclCheckKey = "redis_exporter:acl:check"
+
+// CheckScrapersACL dry-runs the commands of the scrapers as username with ACL DRYRUN
+// and logs the ones the user is not allowed to run. It needs redis 7.0 or later.
+func CheckScrapersACL(ctx context.Context, rdb *redis.Client, username string, scrapers []Scraper, logger log.Logger) {
+	for _, scraper := range scrapers {
+		cs, ok := scraper.(CommandsScraper)
+		if !ok {
+			continue
+		}
+
+		for _, command := range cs.Commands() {
+			res, err := rdb.ACLDryRun(ctx, username, command...).Result()
+			if err != nil {
+				level.Warn(logger).Log("msg", "Unable to check the ACL permissions of the exporter user", "err", err)
+				return
+			}
+			if res != "OK" {
+				level.Warn(logger).Log("msg", "Exporter user is not allowed to run a scraper command", "scraper", scraper.Name(), "username", username, "command", fmt.Sprint(command...), "reason", res)
+			}
+		}
+	}
+}
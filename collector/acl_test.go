@@ -0,0 +1,138 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func aclLogEntry(id int64, reason, username, client string, count int64) map[string]interface{} {
+	return map[string]interface{}{
+		"entry-id":    id,
+		"count":       count,
+		"reason":      reason,
+		"context":     "toplevel",
+		"object":      "get",
+		"username":    username,
+		"client-info": "id=3 addr=" + client + " laddr=127.0.0.1:6379 fd=8 name= age=0",
+	}
+}
+
+func TestUpdateACLLog(t *testing.T) {
+	steps := []struct {
+		name    string
+		entries []map[string]interface{}
+		want    map[aclLogCounterKey]float64
+	}{
+		{
+			// The entries found by the first scrape aren't counted.
+			name: "first scrape",
+			entries: []map[string]interface{}{
+				aclLogEntry(1, "auth", "app", "10.0.0.1:5000", 3),
+				aclLogEntry(0, "command", "app", "10.0.0.1:5000", 1),
+			},
+			want: map[aclLogCounterKey]float64{
+				{reason: "auth", username: "app", ip: "10.0.0.1"}:    0,
+				{reason: "command", username: "app", ip: "10.0.0.1"}: 0,
+			},
+		},
+		{
+			name: "grown and new entries",
+			entries: []map[string]interface{}{
+				aclLogEntry(4, "auth", "app", "10.0.0.2:5001", 1),
+				aclLogEntry(2, "auth", "app", "10.0.0.2:5000", 2),
+				aclLogEntry(1, "auth", "app", "10.0.0.1:5000", 5),
+				aclLogEntry(0, "command", "app", "10.0.0.1:5000", 1),
+			},
+			want: map[aclLogCounterKey]float64{
+				{reason: "auth", username: "app", ip: "10.0.0.1"}:    2,
+				{reason: "auth", username: "app", ip: "10.0.0.2"}:    3,
+				{reason: "command", username: "app", ip: "10.0.0.1"}: 0,
+			},
+		},
+		{
+			// ACL LOG RESET starts the entries over.
+			name: "reset",
+			entries: []map[string]interface{}{
+				aclLogEntry(3, "key", "worker", "10.0.0.3:5000", 1),
+			},
+			want: map[aclLogCounterKey]float64{
+				{reason: "auth", username: "app", ip: "10.0.0.1"}:    2,
+				{reason: "auth", username: "app", ip: "10.0.0.2"}:    3,
+				{reason: "command", username: "app", ip: "10.0.0.1"}: 0,
+				{reason: "key", username: "worker", ip: "10.0.0.3"}:  1,
+			},
+		},
+	}
+
+	state := &aclLogState{totals: make(map[aclLogCounterKey]float64)}
+	for _, step := range steps {
+		updateACLLog(state, step.entries)
+		if !reflect.DeepEqual(state.totals, step.want) {
+			t.Errorf("%s: totals = %v, want %v", step.name, state.totals, step.want)
+		}
+	}
+}
+
+// TestUpdateACLLogEmptyFirstScrape checks that the entries appearing after a
+// first scrape with an empty ACL LOG are counted.
+func TestUpdateACLLogEmptyFirstScrape(t *testing.T) {
+	state := &aclLogState{totals: make(map[aclLogCounterKey]float64)}
+	updateACLLog(state, nil)
+	updateACLLog(state, []map[string]interface{}{aclLogEntry(0, "auth", "app", "10.0.0.1:5000", 2)})
+
+	want := map[aclLogCounterKey]float64{{reason: "auth", username: "app", ip: "10.0.0.1"}: 2}
+	if !reflect.DeepEqual(state.totals, want) {
+		t.Errorf("totals = %v, want %v", state.totals, want)
+	}
+}
+
+func TestParseACLList(t *testing.T) {
+	lines := []string{
+		"user default on nopass sanitize-payload ~* &* +@all",
+		"user app on #5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8 ~app:* resetchannels -@all +get +set",
+		"user old off resetpass -@all",
+		"user legacy on nopass allcommands",
+		"not a user line",
+	}
+	want := []*aclUser{
+		{name: "default", enabled: true, nopass: true, allCommands: true},
+		{name: "app", enabled: true},
+		{name: "old"},
+		{name: "legacy", enabled: true, nopass: true, allCommands: true},
+	}
+	if got := parseACLList(lines); !reflect.DeepEqual(got, want) {
+		for _, u := range got {
+			t.Errorf("got %+v", *u)
+		}
+	}
+}
+
+func TestACLScraperForget(t *testing.T) {
+	scraper := NewACLScraper(ACLSettings{})
+	scraper.state["10.0.0.1:6379"] = &aclLogState{totals: make(map[aclLogCounterKey]float64)}
+	scraper.state["10.0.0.2:6379"] = &aclLogState{totals: make(map[aclLogCounterKey]float64)}
+
+	scraper.Forget("10.0.0.1:6379")
+	if _, ok := scraper.state["10.0.0.1:6379"]; ok {
+		t.Error("state of the forgotten node kept")
+	}
+	if _, ok := scraper.state["10.0.0.2:6379"]; !ok {
+		t.Error("state of the other node dropped")
+	}
+}
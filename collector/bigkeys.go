@@ -0,0 +1,455 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// BigKeysSettings configures the big key scanner, zero values use the defaults.
+type BigKeysSettings struct {
+	// Interval between two big key scans of the keyspace, negative disables
+	// the scans. Defaults to 1h.
+	Interval time.Duration
+	// TopN is the number of biggest keys kept per db and type, both by element
+	// count and by memory usage. Defaults to 10.
+	TopN int
+	// ScanCount is the COUNT hint of each SCAN call. Defaults to 100.
+	ScanCount int64
+	// RateLimit is the maximum number of keys inspected per second on each
+	// node, negative disables the limit. Defaults to 1000.
+	RateLimit int
+}
+
+var (
+	bigKeySizeBytes = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "bigkey", "size_bytes"),
+		"Memory usage of the biggest keys found by the last big key scan.",
+		[]string{"addr", "db", "type", "key"},
+		nil,
+	)
+	bigKeyElements = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "bigkey", "elements"),
+		"Number of elements (bytes for strings) of the biggest keys found by the last big key scan.",
+		[]string{"addr", "db", "type", "key"},
+		nil,
+	)
+	bigKeyScanKeysScanned = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "bigkey", "scan_keys_scanned"),
+		"Number of keys inspected by the current big key scan.",
+		[]string{"addr"},
+		nil,
+	)
+	bigKeyScanProgress = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "bigkey", "scan_progress_ratio"),
+		"Estimated progress of the current big key scan, from 0 to 1.",
+		[]string{"addr"},
+		nil,
+	)
+	bigKeyScanDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "bigkey", "scan_duration_seconds"),
+		"Duration of the last completed big key scan.",
+		[]string{"addr"},
+		nil,
+	)
+	bigKeyScanLastCompleted = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "bigkey", "scan_last_completed_timestamp_seconds"),
+		"Unix timestamp of the last completed big key scan.",
+		[]string{"addr"},
+		nil,
+	)
+)
+
+// bigKeyElementsCmd is the command returning the number of elements of each key type.
+var bigKeyElementsCmd = map[string]string{
+	"string": "STRLEN",
+	"list":   "LLEN",
+	"set":    "SCARD",
+	"zset":   "ZCARD",
+	"hash":   "HLEN",
+	"stream": "XLEN",
+}
+
+type bigKey struct {
+	db       int
+	keyType  string
+	key      string
+	elements float64
+	size     float64
+}
+
+type bigKeyGroup struct {
+	db      int
+	keyType string
+}
+
+// bigKeyTop keeps the top-n keys of a db and type by element count and by memory usage.
+type bigKeyTop struct {
+	byElements []*bigKey
+	bySize     []*bigKey
+}
+
+func insertTopN(top []*bigKey, k *bigKey, n int, less func(a, b *bigKey) bool) []*bigKey {
+	i := sort.Search(len(top), func(i int) bool { return less(top[i], k) })
+	if i >= n {
+		return top
+	}
+	top = append(top, nil)
+	copy(top[i+1:], top[i:])
+	top[i] = k
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+func (t *bigKeyTop) add(k *bigKey, n int) {
+	t.byElements = insertTopN(t.byElements, k, n, func(a, b *bigKey) bool { return a.elements < b.elements })
+	t.bySize = insertTopN(t.bySize, k, n, func(a, b *bigKey) bool { return a.size < b.size })
+}
+
+// keys returns the union of the top keys by element count and by memory usage.
+func (t *bigKeyTop) keys() []*bigKey {
+	seen := make(map[*bigKey]bool, len(t.byElements)+len(t.bySize))
+	keys := make([]*bigKey, 0, len(t.byElements)+len(t.bySize))
+	for _, top := range [][]*bigKey{t.byElements, t.bySize} {
+		for _, k := range top {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+type bigKeysResult struct {
+	keys         []*bigKey
+	duration     time.Duration
+	lastComplete time.Time
+}
+
+type bigKeysProgress struct {
+	scanned int64
+	total   int64
+}
+
+// bigKeysScraper walks the keyspace of every node with SCAN on its own schedule
+// and only exports the results of the last completed scan when scraped.
+type bigKeysScraper struct {
+	settings BigKeysSettings
+
+	mu       sync.Mutex
+	results  map[string]*bigKeysResult
+	progress map[string]*bigKeysProgress
+}
+
+func NewBigKeysScraper(settings BigKeysSettings) *bigKeysScraper {
+	if settings.Interval == 0 {
+		settings.Interval = time.Hour
+	}
+	settings.TopN = intSetting(settings.TopN, 10)
+	if settings.ScanCount == 0 {
+		settings.ScanCount = 100
+	}
+	settings.RateLimit = intSetting(settings.RateLimit, 1000)
+
+	return &bigKeysScraper{
+		settings: settings,
+		results:  make(map[string]*bigKeysResult),
+		progress: make(map[string]*bigKeysProgress),
+	}
+}
+
+// Start implements BackgroundScraper.
+func (scraper *bigKeysScraper) Start(ctx context.Context, nodes func(context.Context) []*redis.Options, logger log.Logger) {
+	if scraper.settings.Interval <= 0 {
+		level.Info(logger).Log("msg", "big key scans disabled", "interval", scraper.settings.Interval)
+		return
+	}
+
+	ticker := time.NewTicker(scraper.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		opts := nodes(ctx)
+		for _, opt := range opts {
+			if err := scraper.scanNode(ctx, opt, logger); err != nil {
+				level.Error(logger).Log("msg", fmt.Sprintf("big key scan failed on %s", opt.Addr), "err", err)
+			}
+		}
+		scraper.prune(opts)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune drops the results of the nodes which aren't scanned anymore.
+func (scraper *bigKeysScraper) prune(opts []*redis.Options) {
+	addrs := make(map[string]bool, len(opts))
+	for _, opt := range opts {
+		addrs[opt.Addr] = true
+	}
+
+	scraper.mu.Lock()
+	defer scraper.mu.Unlock()
+	for addr := range scraper.results {
+		if !addrs[addr] {
+			delete(scraper.results, addr)
+		}
+	}
+	for addr := range scraper.progress {
+		if !addrs[addr] {
+			delete(scraper.progress, addr)
+		}
+	}
+}
+
+func (scraper *bigKeysScraper) setProgress(addr string, scanned, total int64) {
+	scraper.mu.Lock()
+	defer scraper.mu.Unlock()
+	scraper.progress[addr] = &bigKeysProgress{scanned: scanned, total: total}
+}
+
+func (scraper *bigKeysScraper) scanNode(ctx context.Context, opt *redis.Options, logger log.Logger) error {
+	startTime := time.Now()
+
+	rdb := NewClient(opt)
+	defer rdb.Close()
+
+	dbs, err := GetRedisKeyspaceDBs(ctx, rdb)
+	if err != nil {
+		return err
+	}
+
+	var total, scanned int64
+	for _, keys := range dbs {
+		total += keys
+	}
+	scraper.setProgress(opt.Addr, 0, total)
+
+	tops := make(map[bigKeyGroup]*bigKeyTop)
+	for db := range dbs {
+		dbRdb := newDBClient(rdb, db)
+
+		err = scanBigKeys(ctx, dbRdb, db, &scraper.settings, tops, func(n int) {
+			scanned += int64(n)
+			scraper.setProgress(opt.Addr, scanned, total)
+		})
+		dbRdb.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	var keys []*bigKey
+	for _, top := range tops {
+		keys = append(keys, top.keys()...)
+	}
+
+	scraper.mu.Lock()
+	defer scraper.mu.Unlock()
+	scraper.results[opt.Addr] = &bigKeysResult{
+		keys:         keys,
+		duration:     time.Since(startTime),
+		lastComplete: time.Now(),
+	}
+	level.Debug(logger).Log("msg", fmt.Sprintf("big key scan finished on %s", opt.Addr), "keys", scanned)
+
+	return nil
+}
+
+// scanBigKeys walks one db with SCAN, no faster than the configured rate limit,
+// and adds every key to the top-n of its type.
+func scanBigKeys(ctx context.Context, rdb *redis.Client, db int, settings *BigKeysSettings, tops map[bigKeyGroup]*bigKeyTop, onBatch func(n int)) error {
+	var cursor uint64
+	for {
+		batchStart := time.Now()
+
+		keys, next, err := rdb.Scan(ctx, cursor, "", settings.ScanCount).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			bigKeys, err := inspectBigKeys(ctx, rdb, db, keys)
+			if err != nil {
+				return err
+			}
+			for _, k := range bigKeys {
+				group := bigKeyGroup{db: k.db, keyType: k.keyType}
+				top, ok := tops[group]
+				if !ok {
+					top = &bigKeyTop{}
+					tops[group] = top
+				}
+				top.add(k, settings.TopN)
+			}
+			onBatch(len(keys))
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+
+		if settings.RateLimit > 0 {
+			wait := time.Duration(len(keys))*time.Second/time.Duration(settings.RateLimit) - time.Since(batchStart)
+			if wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		}
+	}
+}
+
+// inspectBigKeys reads the type, element count and memory usage of keys with two
+// pipelines. The keys whose commands fail, like a key replaced by one of another
+// type in between, are skipped.
+func inspectBigKeys(ctx context.Context, rdb *redis.Client, db int, keys []string) ([]*bigKey, error) {
+	pipe := rdb.Pipeline()
+	typeCmds := make([]*redis.StatusCmd, len(keys))
+	for i, key := range keys {
+		typeCmds[i] = pipe.Type(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); pipelineError(err) != nil {
+		return nil, err
+	}
+
+	pipe = rdb.Pipeline()
+	bigKeys := make([]*bigKey, 0, len(keys))
+	elementsCmds := make([]*redis.Cmd, 0, len(keys))
+	sizeCmds := make([]*redis.IntCmd, 0, len(keys))
+	for i, key := range keys {
+		keyType := typeCmds[i].Val()
+		cmd, ok := bigKeyElementsCmd[keyType]
+		if !ok {
+			// The key expired in between or is a module type.
+			continue
+		}
+		bigKeys = append(bigKeys, &bigKey{db: db, keyType: keyType, key: key})
+		elementsCmds = append(elementsCmds, pipe.Do(ctx, cmd, key))
+		sizeCmds = append(sizeCmds, pipe.MemoryUsage(ctx, key))
+	}
+	if len(bigKeys) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); pipelineError(err) != nil {
+		return nil, err
+	}
+
+	inspected := bigKeys[:0]
+	for i, k := range bigKeys {
+		elements, err := elementsCmds[i].Int64()
+		if err != nil {
+			continue
+		}
+		size, err := sizeCmds[i].Result()
+		if err != nil {
+			continue
+		}
+		k.elements, k.size = float64(elements), float64(size)
+		inspected = append(inspected, k)
+	}
+
+	return inspected, nil
+}
+
+// Scrape implements Scraper.
+func (scraper *bigKeysScraper) Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error {
+	scraper.mu.Lock()
+	defer scraper.mu.Unlock()
+
+	for _, rdb := range sc.Clients {
+		addr := rdb.Options().Addr
+
+		if progress, ok := scraper.progress[addr]; ok {
+			ratio := 1.0
+			if progress.total > 0 && progress.scanned < progress.total {
+				ratio = float64(progress.scanned) / float64(progress.total)
+			}
+			ch <- prometheus.MustNewConstMetric(bigKeyScanKeysScanned, prometheus.GaugeValue, float64(progress.scanned), addr)
+			ch <- prometheus.MustNewConstMetric(bigKeyScanProgress, prometheus.GaugeValue, ratio, addr)
+		}
+
+		result, ok := scraper.results[addr]
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(bigKeyScanDuration, prometheus.GaugeValue, result.duration.Seconds(), addr)
+		ch <- prometheus.MustNewConstMetric(bigKeyScanLastCompleted, prometheus.GaugeValue, float64(result.lastComplete.Unix()), addr)
+		for _, k := range result.keys {
+			db := strconv.Itoa(k.db)
+			ch <- prometheus.MustNewConstMetric(bigKeySizeBytes, prometheus.GaugeValue, k.size, addr, db, k.keyType, k.key)
+			ch <- prometheus.MustNewConstMetric(bigKeyElements, prometheus.GaugeValue, k.elements, addr, db, k.keyType, k.key)
+		}
+	}
+
+	return nil
+}
+
+// Help implements Scraper.
+func (*bigKeysScraper) Help() string {
+	return "Collect the biggest keys per type from a rate limited background scan of each redis node."
+}
+
+// Name implements Scraper.
+func (*bigKeysScraper) Name() string {
+	return "bigkeys"
+}
+
+// Version implements Scraper.
+func (*bigKeysScraper) Version() string {
+	return "4.0"
+}
+
+// Commands implements CommandsScraper.
+func (*bigKeysScraper) Commands() [][]interface{} {
+	return [][]interface{}{
+		{"info", "keyspace"},
+		{"select", "1"},
+		{"scan", "0"},
+		{"type", aclCheckKey},
+		{"strlen", aclCheckKey},
+		{"llen", aclCheckKey},
+		{"scard", aclCheckKey},
+		{"zcard", aclCheckKey},
+		{"hlen", aclCheckKey},
+		{"xlen", aclCheckKey},
+		{"memory", "usage", aclCheckKey},
+	}
+}
+
+var _ BackgroundScraper = &bigKeysScraper{}
+var _ CommandsScraper = &bigKeysScraper{}
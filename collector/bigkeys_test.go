@@ -0,0 +1,153 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/redis/go-redis/v9"
+	"github.com/xieyanke/redis_exporter/internal/redistest"
+)
+
+func bigKeyNames(keys []*bigKey) []string {
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		names = append(names, k.key)
+	}
+	return names
+}
+
+func TestBigKeyTop(t *testing.T) {
+	top := &bigKeyTop{}
+	for _, k := range []*bigKey{
+		{key: "a", elements: 10, size: 100},
+		{key: "b", elements: 30, size: 50},
+		{key: "c", elements: 20, size: 400},
+		{key: "d", elements: 5, size: 300},
+		{key: "e", elements: 30, size: 10},
+	} {
+		top.add(k, 2)
+	}
+
+	// Ties keep the key found first.
+	if got, want := bigKeyNames(top.byElements), []string{"b", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("top by elements = %v, want %v", got, want)
+	}
+	if got, want := bigKeyNames(top.bySize), []string{"c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("top by size = %v, want %v", got, want)
+	}
+	if got, want := bigKeyNames(top.keys()), []string{"b", "e", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
+
+// TestScanBigKeys walks a keyspace of two SCAN pages, with a key expiring
+// between SCAN and TYPE and a key replaced by one of another type between TYPE
+// and SCARD.
+func TestScanBigKeys(t *testing.T) {
+	type key struct {
+		keyType  string
+		elements int64
+		size     int64
+	}
+	keys := map[string]key{
+		"s1": {"string", 10, 100},
+		"s2": {"string", 500, 600},
+		"s3": {"string", 20, 90},
+		"l1": {"list", 7, 200},
+		"h1": {"hash", 3, 1000},
+		"r1": {"set", 0, 5000},
+	}
+	pages := map[string]string{
+		"0": redistest.Array(redistest.Bulk("1"), redistest.Array(redistest.Bulk("s1"), redistest.Bulk("l1"), redistest.Bulk("gone"))),
+		"1": redistest.Array(redistest.Bulk("0"), redistest.Array(redistest.Bulk("s2"), redistest.Bulk("s3"), redistest.Bulk("r1"), redistest.Bulk("h1"))),
+	}
+
+	s := redistest.NewServer(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "SCAN":
+			return pages[args[1]]
+		case "TYPE":
+			if k, ok := keys[args[1]]; ok {
+				return redistest.Status(k.keyType)
+			}
+			return redistest.Status("none")
+		case "SCARD":
+			return redistest.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+		case "STRLEN", "LLEN", "HLEN":
+			return redistest.Int(keys[args[1]].elements)
+		case "MEMORY":
+			return redistest.Int(keys[args[2]].size)
+		}
+		return ""
+	})
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr})
+	defer rdb.Close()
+
+	tops := make(map[bigKeyGroup]*bigKeyTop)
+	var batches []int
+	if err := scanBigKeys(context.Background(), rdb, 0, &BigKeysSettings{TopN: 2, ScanCount: 3}, tops, func(n int) { batches = append(batches, n) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{3, 4}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+	got := map[string][]string{}
+	for group, top := range tops {
+		for _, k := range top.keys() {
+			want := keys[k.key]
+			if k.elements != float64(want.elements) || k.size != float64(want.size) {
+				t.Errorf("%s: got %v elements %v bytes, want %d elements %d bytes", k.key, k.elements, k.size, want.elements, want.size)
+			}
+		}
+		got[strconv.Itoa(group.db)+"/"+group.keyType] = bigKeyNames(top.keys())
+	}
+	want := map[string][]string{
+		"0/string": {"s2", "s3", "s1"},
+		"0/list":   {"l1"},
+		"0/hash":   {"h1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tops = %v, want %v", got, want)
+	}
+}
+
+// TestBigKeysStartDisabled checks that a negative interval disables the scans.
+func TestBigKeysStartDisabled(t *testing.T) {
+	scraper := NewBigKeysScraper(BigKeysSettings{Interval: -time.Second})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scraper.Start(context.Background(), func(context.Context) []*redis.Options {
+			t.Error("nodes listed with the scans disabled")
+			return nil
+		}, log.NewNopLogger())
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Start didn't return")
+	}
+}
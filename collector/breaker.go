@@ -0,0 +1,239 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	redis "github.com/redis/go-redis/v9"
+)
+
+var errNodeUnavailable = errors.New("node skipped by the circuit breaker")
+
+var (
+	redisNodeBreakerOpen = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, subsystem, "node_breaker_open"),
+		"Whether the circuit breaker of the redis node is open, the node is skipped until a probe succeeds.",
+		[]string{"addr"},
+		nil,
+	)
+	redisNodeBreakerFailures = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, subsystem, "node_breaker_failures"),
+		"Number of consecutive failed scrapes of the redis node.",
+		[]string{"addr"},
+		nil,
+	)
+	redisNodeStale = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, subsystem, "node_stale"),
+		"Whether some metrics of the redis node are the last known values rather than fresh ones.",
+		[]string{"addr"},
+		nil,
+	)
+)
+
+// isNodeDown reports whether err means the node is unreachable or too slow,
+// rather than a command failing.
+func isNodeDown(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// staleMetrics are the metrics of the last successful scrape of a node by a scraper.
+type staleMetrics struct {
+	metrics []prometheus.Metric
+	time    time.Time
+}
+
+type breakerNode struct {
+	failures    int
+	openUntil   time.Time
+	lastSuccess time.Time
+	stale       map[string]*staleMetrics
+}
+
+// Breaker is a per node circuit breaker, shared by the exporters. After a
+// failed scrape a node is skipped for a backoff doubling on every failure, from
+// minBackoff up to maxBackoff, then scraped again once a PING succeeds. The
+// metrics of a node are kept for staleFor, 0 disables it, and exported in place
+// of those a scrape fails to get.
+type Breaker struct {
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	staleFor   time.Duration
+
+	mu    sync.Mutex
+	nodes map[string]*breakerNode
+}
+
+// NewBreaker returns a circuit breaker with the given backoff bounds, keeping
+// the last known metrics of the nodes for staleFor.
+func NewBreaker(minBackoff, maxBackoff, staleFor time.Duration) *Breaker {
+	return &Breaker{
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		staleFor:   staleFor,
+		nodes:      make(map[string]*breakerNode),
+	}
+}
+
+func (b *Breaker) node(addr string) *breakerNode {
+	n, ok := b.nodes[addr]
+	if !ok {
+		n = &breakerNode{stale: make(map[string]*staleMetrics)}
+		b.nodes[addr] = n
+	}
+	return n
+}
+
+// allow reports whether rdb can be scraped. A node whose breaker is open is
+// probed with a PING once its backoff has elapsed, the error of a failed probe
+// is returned for the caller to record.
+func (b *Breaker) allow(ctx context.Context, rdb *redis.Client) (bool, error) {
+	addr := rdb.Options().Addr
+
+	b.mu.Lock()
+	n := b.node(addr)
+	openUntil := n.openUntil
+	b.mu.Unlock()
+
+	if openUntil.IsZero() {
+		return true, nil
+	}
+	if time.Now().Before(openUntil) {
+		return false, nil
+	}
+
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return false, err
+	}
+
+	b.mu.Lock()
+	n.openUntil = time.Time{}
+	b.mu.Unlock()
+	return true, nil
+}
+
+// record updates the breaker of addr with the outcome of a scrape.
+func (b *Breaker) record(addr string, down bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.node(addr)
+	if !down {
+		n.failures = 0
+		n.openUntil = time.Time{}
+		n.lastSuccess = time.Now()
+		return
+	}
+
+	n.failures++
+	backoff := b.minBackoff
+	for i := 1; i < n.failures && backoff < b.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > b.maxBackoff {
+		backoff = b.maxBackoff
+	}
+	n.openUntil = time.Now().Add(backoff)
+}
+
+// store keeps the metrics of a successful scrape of addr by scraper.
+func (b *Breaker) store(addr, scraper string, metrics []prometheus.Metric) {
+	if b.staleFor <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.node(addr).stale[scraper] = &staleMetrics{metrics: metrics, time: time.Now()}
+}
+
+// stale returns the last known metrics of addr by scraper, nil if there are
+// none younger than staleFor.
+func (b *Breaker) stale(addr, scraper string) []prometheus.Metric {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.node(addr)
+	s, ok := n.stale[scraper]
+	if !ok {
+		return nil
+	}
+	if time.Since(s.time) > b.staleFor {
+		delete(n.stale, scraper)
+		return nil
+	}
+	return s.metrics
+}
+
+func (b *Breaker) collect(addr string, ch chan<- prometheus.Metric) {
+	b.mu.Lock()
+	n := b.node(addr)
+	open, failures := !n.openUntil.IsZero(), n.failures
+	b.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(redisNodeBreakerOpen, prometheus.GaugeValue, boolToFloat64(open), addr)
+	ch <- prometheus.MustNewConstMetric(redisNodeBreakerFailures, prometheus.GaugeValue, float64(failures), addr)
+}
+
+// NodeStatus is the breaker state of a node.
+type NodeStatus struct {
+	Addr        string
+	Open        bool
+	Failures    int
+	NextProbe   time.Time
+	LastSuccess time.Time
+}
+
+// Status returns the breaker state of the nodes scraped so far, by address.
+func (b *Breaker) Status() []NodeStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := make([]NodeStatus, 0, len(b.nodes))
+	for addr, n := range b.nodes {
+		status = append(status, NodeStatus{
+			Addr:        addr,
+			Open:        !n.openUntil.IsZero(),
+			Failures:    n.failures,
+			NextProbe:   n.openUntil,
+			LastSuccess: n.lastSuccess,
+		})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Addr < status[j].Addr })
+	return status
+}
+
+// Forget drops the state of a node which isn't scraped anymore.
+func (b *Breaker) Forget(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.nodes, addr)
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// AuthFailuresTotal counts the commands rejected because the exporter failed to
+// authenticate, it has to be registered by the program using the collector.
+var AuthFailuresTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: subsystem,
+		Name:      "auth_failures_total",
+		Help:      "Number of redis authentication failures.",
+	},
+	[]string{"addr"},
+)
+
+// IsAuthError reports whether err is a redis authentication failure.
+func IsAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.HasPrefix(msg, "WRONGPASS") ||
+		strings.HasPrefix(msg, "NOAUTH") ||
+		strings.Contains(msg, "invalid password") ||
+		strings.Contains(msg, "invalid username-password pair")
+}
+
+// authFailuresHook counts the authentication failures of a client.
+type authFailuresHook struct {
+	addr string
+}
+
+func (h authFailuresHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h authFailuresHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if IsAuthError(err) {
+			AuthFailuresTotal.WithLabelValues(h.addr).Inc()
+		}
+		return err
+	}
+}
+
+func (h authFailuresHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		if IsAuthError(err) {
+			AuthFailuresTotal.WithLabelValues(h.addr).Inc()
+		}
+		return err
+	}
+}
+
+var _ redis.Hook = authFailuresHook{}
+
+// NewClient returns a redis client which counts its authentication failures
+// and stops its commands at the deadline of their context. Every client of the
+// exporter should be created with it.
+func NewClient(opt *redis.Options) *redis.Client {
+	o := *opt
+	o.ContextTimeoutEnabled = true
+	rdb := redis.NewClient(&o)
+	rdb.AddHook(authFailuresHook{addr: opt.Addr})
+	return rdb
+}
+
+// newDBClient returns a client of the same node as rdb which selects db.
+func newDBClient(rdb *redis.Client, db int) *redis.Client {
+	opt := *rdb.Options()
+	opt.DB = db
+	return NewClient(&opt)
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const clientListOtherGroup = "other"
+
+// ClientListSettings configures the clients scraper, zero values use the defaults.
+type ClientListSettings struct {
+	// GroupByIP also groups the clients by source ip.
+	GroupByIP bool
+	// MaxGroups is the maximum number of client groups exported per node, the
+	// rest are folded into the 'other' group. Defaults to 50.
+	MaxGroups int
+}
+
+var clientListLabels = []string{"addr", "name", "user", "ip"}
+
+var (
+	clientListConnections = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "clients", "connections_in_total"),
+		"Number of client connections in the group.",
+		clientListLabels,
+		nil,
+	)
+	clientListMaxIdle = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "clients", "max_idle_in_seconds"),
+		"Biggest idle time among client connections in the group.",
+		clientListLabels,
+		nil,
+	)
+	clientListOutputMemory = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "clients", "output_memory_in_bytes"),
+		"Sum of output buffer memory (omem) used by client connections in the group.",
+		clientListLabels,
+		nil,
+	)
+	clientListTotalMemory = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "clients", "total_memory_in_bytes"),
+		"Sum of total memory (tot-mem) used by client connections in the group.",
+		clientListLabels,
+		nil,
+	)
+	clientListBlocked = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "clients", "blocked_in_total"),
+		"Number of client connections in the group pending on a blocking call.",
+		clientListLabels,
+		nil,
+	)
+	clientListPubSub = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "clients", "pubsub_subscribers_in_total"),
+		"Number of client connections in the group subscribed to at least one channel or pattern.",
+		clientListLabels,
+		nil,
+	)
+)
+
+type clientGroupKey struct {
+	name string
+	user string
+	ip   string
+}
+
+type clientGroupStats struct {
+	connections float64
+	maxIdle     float64
+	outputMem   float64
+	totalMem    float64
+	blocked     float64
+	pubsub      float64
+}
+
+func (s *clientGroupStats) merge(o *clientGroupStats) {
+	s.connections += o.connections
+	if o.maxIdle > s.maxIdle {
+		s.maxIdle = o.maxIdle
+	}
+	s.outputMem += o.outputMem
+	s.totalMem += o.totalMem
+	s.blocked += o.blocked
+	s.pubsub += o.pubsub
+}
+
+// parseClientListResp parses a CLIENT LIST reply into one field map per client.
+func parseClientListResp(resp string) []map[string]string {
+	resp = strings.TrimSpace(resp)
+	if resp == "" {
+		return nil
+	}
+
+	lines := strings.Split(resp, "\n")
+	clients := make([]map[string]string, 0, len(lines))
+	for _, line := range lines {
+		fields := make(map[string]string, 24)
+		for _, item := range strings.Fields(line) {
+			itemArr := strings.SplitN(item, "=", 2)
+			if len(itemArr) != 2 {
+				continue
+			}
+			fields[itemArr[0]] = itemArr[1]
+		}
+		clients = append(clients, fields)
+	}
+
+	return clients
+}
+
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			return addr[:i]
+		}
+		return addr
+	}
+	return host
+}
+
+func aggregateClientList(clients []map[string]string, groupByIP bool, maxGroups int) map[clientGroupKey]*clientGroupStats {
+	groups := make(map[clientGroupKey]*clientGroupStats)
+	for _, c := range clients {
+		key := clientGroupKey{name: c["name"], user: c["user"]}
+		if groupByIP {
+			key.ip = clientIP(c["addr"])
+		}
+
+		stats, ok := groups[key]
+		if !ok {
+			stats = &clientGroupStats{}
+			groups[key] = stats
+		}
+
+		stats.connections++
+		if idle, err := strconv.ParseFloat(c["idle"], 64); err == nil && idle > stats.maxIdle {
+			stats.maxIdle = idle
+		}
+		if omem, err := strconv.ParseFloat(c["omem"], 64); err == nil {
+			stats.outputMem += omem
+		}
+		if totMem, err := strconv.ParseFloat(c["tot-mem"], 64); err == nil {
+			stats.totalMem += totMem
+		}
+		if strings.Contains(c["flags"], "b") {
+			stats.blocked++
+		}
+		if clientSubscriptions(c) > 0 {
+			stats.pubsub++
+		}
+	}
+
+	if maxGroups <= 0 || len(groups) <= maxGroups {
+		return groups
+	}
+
+	keys := make([]clientGroupKey, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := groups[keys[i]].connections, groups[keys[j]].connections
+		if ci != cj {
+			return ci > cj
+		}
+		return keys[i].name+keys[i].user+keys[i].ip < keys[j].name+keys[j].user+keys[j].ip
+	})
+
+	// Keep one slot for the 'other' group so the cap is a hard limit.
+	other := &clientGroupStats{}
+	for _, k := range keys[maxGroups-1:] {
+		other.merge(groups[k])
+		delete(groups, k)
+	}
+	otherKey := clientGroupKey{name: clientListOtherGroup, user: clientListOtherGroup}
+	if groupByIP {
+		otherKey.ip = clientListOtherGroup
+	}
+	if stats, ok := groups[otherKey]; ok {
+		stats.merge(other)
+	} else {
+		groups[otherKey] = other
+	}
+
+	return groups
+}
+
+func clientSubscriptions(c map[string]string) int {
+	var n int
+	for _, field := range []string{"sub", "psub", "ssub"} {
+		if v, err := strconv.Atoi(c[field]); err == nil {
+			n += v
+		}
+	}
+	return n
+}
+
+type clientListScraper struct {
+	settings ClientListSettings
+}
+
+func NewClientListScraper(settings ClientListSettings) *clientListScraper {
+	settings.MaxGroups = intSetting(settings.MaxGroups, 50)
+	return &clientListScraper{settings: settings}
+}
+
+// Scrape implements Scraper.
+func (scraper *clientListScraper) Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error {
+	var err error
+
+	for _, rdb := range sc.Clients {
+		addr := rdb.Options().Addr
+
+		var res string
+		res, err = rdb.ClientList(ctx).Result()
+		if err != nil {
+			return err
+		}
+
+		groups := aggregateClientList(parseClientListResp(res), scraper.settings.GroupByIP, scraper.settings.MaxGroups)
+		for k, v := range groups {
+			labels := []string{addr, k.name, k.user, k.ip}
+			ch <- prometheus.MustNewConstMetric(clientListConnections, prometheus.GaugeValue, v.connections, labels...)
+			ch <- prometheus.MustNewConstMetric(clientListMaxIdle, prometheus.GaugeValue, v.maxIdle, labels...)
+			ch <- prometheus.MustNewConstMetric(clientListOutputMemory, prometheus.GaugeValue, v.outputMem, labels...)
+			ch <- prometheus.MustNewConstMetric(clientListTotalMemory, prometheus.GaugeValue, v.totalMem, labels...)
+			ch <- prometheus.MustNewConstMetric(clientListBlocked, prometheus.GaugeValue, v.blocked, labels...)
+			ch <- prometheus.MustNewConstMetric(clientListPubSub, prometheus.GaugeValue, v.pubsub, labels...)
+		}
+	}
+
+	return err
+}
+
+// Help implements Scraper.
+func (*clientListScraper) Help() string {
+	return "Collect client list aggregated by client name, user and optionally ip from each redis node."
+}
+
+// Name implements Scraper.
+func (*clientListScraper) Name() string {
+	return "clients"
+}
+
+// Version implements Scraper.
+func (*clientListScraper) Version() string {
+	return "2.4"
+}
+
+// Commands implements CommandsScraper.
+func (*clientListScraper) Commands() [][]interface{} {
+	return [][]interface{}{
+		{"client", "list"},
+	}
+}
+
+var _ CommandsScraper = &clientListScraper{}
@@ -0,0 +1,124 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseClientListResp(t *testing.T) {
+	resp := "id=3 addr=10.0.0.1:52614 laddr=10.0.0.9:6379 fd=8 name=api age=10 idle=2 flags=N db=0 sub=0 psub=0 omem=0 tot-mem=20000 cmd=client|list user=default\n" +
+		"id=4 addr=[fd00::2]:40000 fd=9 name= age=3 idle=7 flags=b sub=1 cmd=blpop user=app lib-name=go-redis(,go1.21) malformed\n"
+
+	want := []map[string]string{
+		{
+			"id": "3", "addr": "10.0.0.1:52614", "laddr": "10.0.0.9:6379", "fd": "8", "name": "api", "age": "10", "idle": "2",
+			"flags": "N", "db": "0", "sub": "0", "psub": "0", "omem": "0", "tot-mem": "20000", "cmd": "client|list", "user": "default",
+		},
+		{
+			"id": "4", "addr": "[fd00::2]:40000", "fd": "9", "name": "", "age": "3", "idle": "7", "flags": "b", "sub": "1",
+			"cmd": "blpop", "user": "app", "lib-name": "go-redis(,go1.21)",
+		},
+	}
+	if got := parseClientListResp(resp); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := parseClientListResp("\n"); got != nil {
+		t.Errorf("empty reply: got %v, want nil", got)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	for addr, want := range map[string]string{
+		"10.0.0.1:52614":  "10.0.0.1",
+		"[fd00::2]:40000": "fd00::2",
+		"fd00::2:40000":   "fd00::2",
+		"/tmp/redis.sock": "/tmp/redis.sock",
+	} {
+		if got := clientIP(addr); got != want {
+			t.Errorf("clientIP(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestAggregateClientList(t *testing.T) {
+	clients := []map[string]string{
+		{"name": "api", "user": "app", "addr": "10.0.0.1:1", "idle": "5", "omem": "10", "tot-mem": "100", "flags": "N"},
+		{"name": "api", "user": "app", "addr": "10.0.0.1:2", "idle": "9", "omem": "0", "tot-mem": "100", "flags": "b"},
+		{"name": "api", "user": "app", "addr": "10.0.0.2:1", "idle": "1", "tot-mem": "50", "flags": "P", "sub": "2"},
+		{"name": "worker", "user": "app", "addr": "10.0.0.3:1", "idle": "3", "tot-mem": "70", "flags": "N", "ssub": "1"},
+		{"name": "", "user": "default", "addr": "10.0.0.4:1", "idle": "0", "tot-mem": "20", "flags": "N"},
+	}
+
+	tests := []struct {
+		name      string
+		groupByIP bool
+		maxGroups int
+		want      map[clientGroupKey]*clientGroupStats
+	}{
+		{
+			name: "by name and user",
+			want: map[clientGroupKey]*clientGroupStats{
+				{name: "api", user: "app"}:    {connections: 3, maxIdle: 9, outputMem: 10, totalMem: 250, blocked: 1, pubsub: 1},
+				{name: "worker", user: "app"}: {connections: 1, maxIdle: 3, totalMem: 70, pubsub: 1},
+				{name: "", user: "default"}:   {connections: 1, totalMem: 20},
+			},
+		},
+		{
+			name:      "by ip",
+			groupByIP: true,
+			want: map[clientGroupKey]*clientGroupStats{
+				{name: "api", user: "app", ip: "10.0.0.1"}:    {connections: 2, maxIdle: 9, outputMem: 10, totalMem: 200, blocked: 1},
+				{name: "api", user: "app", ip: "10.0.0.2"}:    {connections: 1, maxIdle: 1, totalMem: 50, pubsub: 1},
+				{name: "worker", user: "app", ip: "10.0.0.3"}: {connections: 1, maxIdle: 3, totalMem: 70, pubsub: 1},
+				{name: "", user: "default", ip: "10.0.0.4"}:   {connections: 1, totalMem: 20},
+			},
+		},
+		{
+			// The other group takes one of the slots, ties are broken by name,
+			// user and ip.
+			name:      "capped",
+			maxGroups: 2,
+			want: map[clientGroupKey]*clientGroupStats{
+				{name: "api", user: "app"}:     {connections: 3, maxIdle: 9, outputMem: 10, totalMem: 250, blocked: 1, pubsub: 1},
+				{name: "other", user: "other"}: {connections: 2, maxIdle: 3, totalMem: 90, pubsub: 1},
+			},
+		},
+		{
+			name:      "capped by ip",
+			groupByIP: true,
+			maxGroups: 3,
+			want: map[clientGroupKey]*clientGroupStats{
+				{name: "api", user: "app", ip: "10.0.0.1"}:  {connections: 2, maxIdle: 9, outputMem: 10, totalMem: 200, blocked: 1},
+				{name: "api", user: "app", ip: "10.0.0.2"}:  {connections: 1, maxIdle: 1, totalMem: 50, pubsub: 1},
+				{name: "other", user: "other", ip: "other"}: {connections: 2, maxIdle: 3, totalMem: 90, pubsub: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregateClientList(clients, tt.groupByIP, tt.maxGroups)
+			if !reflect.DeepEqual(got, tt.want) {
+				for k, v := range got {
+					t.Errorf("got %+v: %+v", k, *v)
+				}
+			}
+		})
+	}
+}
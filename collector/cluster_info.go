@@ -22,7 +22,6 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
-	redis "github.com/redis/go-redis/v9"
 )
 
 // sharding cluster metrics
@@ -142,10 +141,10 @@ func NewClusterInfoScraper() *clusterInfoScraper {
 }
 
 // Scrape implements Scraper.
-func (scraper *clusterInfoScraper) Scrape(ctx context.Context, rdbs []*redis.Client, ch chan<- prometheus.Metric, logger log.Logger) error {
+func (scraper *clusterInfoScraper) Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error {
 	var err error
 
-	for _, rdb := range rdbs {
+	for _, rdb := range sc.Clients {
 		addr := rdb.Options().Addr
 
 		var res string
@@ -161,14 +160,8 @@ func (scraper *clusterInfoScraper) Scrape(ctx context.Context, rdbs []*redis.Cli
 			f64, err = strconv.ParseFloat(resMap[k], 64)
 			checkParseRedisInfoRespError(k, addr, err, logger)
 
-			desc := prometheus.NewDesc(
-				prometheus.BuildFQName(Namespace, v.Subsystem, v.Name),
-				v.Help,
-				v.Labels,
-				nil,
-			)
 			ch <- prometheus.MustNewConstMetric(
-				desc,
+				v.Desc(),
 				prometheus.GaugeValue,
 				f64,
 				addr,
@@ -194,4 +187,11 @@ func (*clusterInfoScraper) Version() string {
 	return "3.0"
 }
 
-var _ Scraper = &clusterInfoScraper{}
+// Commands implements CommandsScraper.
+func (*clusterInfoScraper) Commands() [][]interface{} {
+	return [][]interface{}{
+		{"cluster", "info"},
+	}
+}
+
+var _ CommandsScraper = &clusterInfoScraper{}
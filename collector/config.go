@@ -0,0 +1,174 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// DefaultConfigParams are the CONFIG GET parameters exported by default.
+var DefaultConfigParams = []string{
+	"maxmemory",
+	"maxmemory-policy",
+	"maxclients",
+	"timeout",
+	"io-threads",
+	"save",
+	"appendonly",
+	"appendfsync",
+	"repl-backlog-size",
+	"repl-backlog-ttl",
+	"client-output-buffer-limit",
+}
+
+// defaultConfigCommand is the name of the CONFIG command when it isn't renamed.
+const defaultConfigCommand = "CONFIG"
+
+// ConfigSettings configures the config scraper, zero values use the defaults.
+type ConfigSettings struct {
+	// Params are the CONFIG GET parameters to export. Defaults to
+	// DefaultConfigParams.
+	Params []string
+	// Command is the name of the CONFIG command, for servers where it has been
+	// renamed. Defaults to CONFIG.
+	Command string
+}
+
+var (
+	configAvailable = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "config", "available"),
+		"Whether the CONFIG GET command is usable on the redis node.",
+		[]string{"addr"},
+		nil,
+	)
+	configInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "config", "info"),
+		"Non numeric redis config values.",
+		[]string{"addr", "param", "value"},
+		nil,
+	)
+)
+
+type configScraper struct {
+	settings ConfigSettings
+}
+
+func NewConfigScraper(settings ConfigSettings) *configScraper {
+	if len(settings.Params) == 0 {
+		settings.Params = DefaultConfigParams
+	}
+	settings.Command = stringSetting(settings.Command, defaultConfigCommand)
+	return &configScraper{settings: settings}
+}
+
+// configMetricName turns a redis config parameter into a valid metric name.
+func configMetricName(param string) string {
+	return strings.NewReplacer("-", "_", ".", "_").Replace(param)
+}
+
+// getRedisConfig runs CONFIG GET for each parameter through the given command name,
+// so it keeps working when CONFIG has been renamed.
+func getRedisConfig(ctx context.Context, rdb *redis.Client, command string, params []string) (map[string]string, error) {
+	m := make(map[string]string, len(params))
+	for _, param := range params {
+		res, err := rdb.Do(ctx, command, "GET", param).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range parseRedisMapReply(res) {
+			m[k] = fmt.Sprint(v)
+		}
+	}
+
+	return m, nil
+}
+
+// Scrape implements Scraper.
+func (scraper *configScraper) Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error {
+	for _, rdb := range sc.Clients {
+		addr := rdb.Options().Addr
+
+		m, err := getRedisConfig(ctx, rdb, scraper.settings.Command, scraper.settings.Params)
+		if err != nil {
+			// CONFIG is commonly renamed or disabled on managed services, which
+			// should not fail the whole scrape.
+			level.Warn(logger).Log("msg", fmt.Sprintf("config get failed from %s", addr), "err", err)
+			ch <- prometheus.MustNewConstMetric(configAvailable, prometheus.GaugeValue, 0, addr)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(configAvailable, prometheus.GaugeValue, 1, addr)
+
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			v := m[k]
+			f64, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				ch <- prometheus.MustNewConstMetric(configInfo, prometheus.GaugeValue, 1, addr, k, v)
+				continue
+			}
+
+			desc := prometheus.NewDesc(
+				prometheus.BuildFQName(Namespace, "config", configMetricName(k)),
+				fmt.Sprintf("The value of redis config %s.", k),
+				[]string{"addr"},
+				nil,
+			)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, f64, addr)
+		}
+	}
+
+	return nil
+}
+
+// Help implements Scraper.
+func (*configScraper) Help() string {
+	return "Collect config values from each redis node."
+}
+
+// Name implements Scraper.
+func (*configScraper) Name() string {
+	return "config"
+}
+
+// Version implements Scraper.
+func (*configScraper) Version() string {
+	return "2.0"
+}
+
+// Commands implements CommandsScraper.
+func (scraper *configScraper) Commands() [][]interface{} {
+	return [][]interface{}{
+		{scraper.settings.Command, "get", "maxmemory"},
+	}
+}
+
+var _ CommandsScraper = &configScraper{}
@@ -0,0 +1,227 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/xieyanke/redis_exporter/internal/redistest"
+)
+
+// newConfigServer returns a server answering CONFIG GET, renamed to command,
+// from config.
+func newConfigServer(t *testing.T, command string, config map[string]string) *redistest.Server {
+	t.Helper()
+
+	return redistest.NewServer(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			return redistest.Status("PONG")
+		case "CLIENT", "SELECT":
+			return redistest.Status("OK")
+		case strings.ToUpper(command):
+			if len(args) != 3 || strings.ToUpper(args[1]) != "GET" {
+				return redistest.Error("ERR wrong number of arguments")
+			}
+			var pairs []string
+			for param, value := range config {
+				if param == args[2] || (strings.HasSuffix(args[2], "*") && strings.HasPrefix(param, strings.TrimSuffix(args[2], "*"))) {
+					pairs = append(pairs, redistest.Bulk(param), redistest.Bulk(value))
+				}
+			}
+			return redistest.Array(pairs...)
+		}
+		return ""
+	})
+}
+
+func TestGetRedisConfig(t *testing.T) {
+	config := map[string]string{
+		"maxmemory":                  "1073741824",
+		"maxmemory-policy":           "allkeys-lfu",
+		"maxmemory-samples":          "5",
+		"save":                       "3600 1 300 100",
+		"client-output-buffer-limit": "normal 0 0 0 slave 268435456 67108864 60",
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		params  []string
+		want    map[string]string
+	}{
+		{
+			name:    "params",
+			command: "CONFIG",
+			params:  []string{"maxmemory", "save"},
+			want:    map[string]string{"maxmemory": "1073741824", "save": "3600 1 300 100"},
+		},
+		{
+			name:    "glob",
+			command: "CONFIG",
+			params:  []string{"maxmemory*"},
+			want:    map[string]string{"maxmemory": "1073741824", "maxmemory-policy": "allkeys-lfu", "maxmemory-samples": "5"},
+		},
+		{
+			name:    "unknown param",
+			command: "CONFIG",
+			params:  []string{"maxmemory", "no-such-param"},
+			want:    map[string]string{"maxmemory": "1073741824"},
+		},
+		{
+			name:    "renamed command",
+			command: "CFG-8f14e45f",
+			params:  []string{"client-output-buffer-limit"},
+			want:    map[string]string{"client-output-buffer-limit": "normal 0 0 0 slave 268435456 67108864 60"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newConfigServer(t, tt.command, config)
+			rdb := redis.NewClient(&redis.Options{Addr: s.Addr})
+			defer rdb.Close()
+
+			got, err := getRedisConfig(context.Background(), rdb, tt.command, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("config = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// gatherConfig scrapes the config scraper with settings from the server at
+// addr, and returns the value of each config series keyed by name and labels.
+func gatherConfig(t *testing.T, addr string, settings ConfigSettings) map[string]float64 {
+	t.Helper()
+
+	e := New([]*redis.Options{{Addr: addr}}, []Scraper{NewConfigScraper(settings)})
+	values := make(map[string]float64)
+	for _, mf := range gather(t, e) {
+		if !strings.HasPrefix(mf.GetName(), "redis_config_") {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				if l.GetName() != "addr" {
+					labels = append(labels, l.GetName()+"="+l.GetValue())
+				}
+			}
+			sort.Strings(labels)
+			values[mf.GetName()+"{"+strings.Join(labels, ",")+"}"] = m.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+// TestConfigScrape checks that the numeric config values are exported as their
+// own gauge and the other ones as redis_config_info.
+func TestConfigScrape(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		params []string
+		want   map[string]float64
+	}{
+		{
+			name:   "numeric",
+			config: map[string]string{"maxmemory": "1073741824", "repl-backlog-ttl": "3600", "io-threads": "4"},
+			params: []string{"maxmemory", "repl-backlog-ttl", "io-threads"},
+			want: map[string]float64{
+				"redis_config_available{}":        1,
+				"redis_config_maxmemory{}":        1073741824,
+				"redis_config_repl_backlog_ttl{}": 3600,
+				"redis_config_io_threads{}":       4,
+			},
+		},
+		{
+			name:   "info",
+			config: map[string]string{"maxmemory-policy": "noeviction", "save": "3600 1", "appendonly": "no"},
+			params: []string{"maxmemory-policy", "save", "appendonly"},
+			want: map[string]float64{
+				"redis_config_available{}":                                   1,
+				"redis_config_info{param=maxmemory-policy,value=noeviction}": 1,
+				"redis_config_info{param=save,value=3600 1}":                 1,
+				"redis_config_info{param=appendonly,value=no}":               1,
+			},
+		},
+		{
+			// An empty save disables RDB snapshots, it isn't a number.
+			name:   "empty value",
+			config: map[string]string{"save": ""},
+			params: []string{"save"},
+			want: map[string]float64{
+				"redis_config_available{}":             1,
+				"redis_config_info{param=save,value=}": 1,
+			},
+		},
+		{
+			name:   "dotted param",
+			config: map[string]string{"cluster.node-timeout": "15000"},
+			params: []string{"cluster.node-timeout"},
+			want: map[string]float64{
+				"redis_config_available{}":            1,
+				"redis_config_cluster_node_timeout{}": 15000,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newConfigServer(t, "CONFIG", tt.config)
+			got := gatherConfig(t, s.Addr, ConfigSettings{Params: tt.params})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("metrics = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestConfigScrapeUnavailable checks that a disabled CONFIG command is reported
+// by redis_config_available rather than failing the scrape.
+func TestConfigScrapeUnavailable(t *testing.T) {
+	s := redistest.NewServer(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			return redistest.Status("PONG")
+		case "CLIENT", "SELECT":
+			return redistest.Status("OK")
+		case "CONFIG":
+			return redistest.Error("ERR unknown command 'CONFIG', with args beginning with: 'GET' 'maxmemory'")
+		}
+		return ""
+	})
+
+	got := gatherConfig(t, s.Addr, ConfigSettings{})
+	if want := map[string]float64{"redis_config_available{}": 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("metrics = %v, want %v", got, want)
+	}
+
+	e := New([]*redis.Options{{Addr: s.Addr}}, []Scraper{NewConfigScraper(ConfigSettings{})})
+	if values := gatherValues(t, e); values["redis_exporter_scrape_success/collect.config"] != 1 {
+		t.Errorf("scrape success = %v, want 1", values["redis_exporter_scrape_success/collect.config"])
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/log"
@@ -55,8 +56,51 @@ var (
 		[]string{"collector"},
 		nil,
 	)
+
+	redisNodeScrapeDurationSeconds = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, subsystem, "node_scrape_duration_seconds"),
+		"Duration of the scrape of a redis node by all the collectors.",
+		[]string{"addr"},
+		nil,
+	)
+
+	redisNodeScrapeSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, subsystem, "node_scrape_success"),
+		"Whether all the collectors scraped the redis node successfully.",
+		[]string{"addr"},
+		nil,
+	)
 )
 
+// reservedLabels are the variable labels of the collector metrics, which can't
+// be used as constant labels.
+var reservedLabels = map[string]bool{
+	"addr":      true,
+	"channel":   true,
+	"collector": true,
+	"db":        true,
+	"ip":        true,
+	"key":       true,
+	"le":        true,
+	"master":    true,
+	"name":      true,
+	"param":     true,
+	"prefix":    true,
+	"reason":    true,
+	"replica":   true,
+	"slot":      true,
+	"type":      true,
+	"user":      true,
+	"username":  true,
+	"value":     true,
+}
+
+// IsReservedLabel reports whether name is a variable label of some metric of
+// the collector.
+func IsReservedLabel(name string) bool {
+	return reservedLabels[name]
+}
+
 type MetricDesc struct {
 	Subsystem string
 	Name      string
@@ -64,17 +108,101 @@ type MetricDesc struct {
 	Labels    []string
 }
 
-// Exporter collects redis metrics.
+// Desc returns the descriptor of the metric, the constant labels of the
+// exporter are added when it is registered.
+func (d *MetricDesc) Desc() *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, d.Subsystem, d.Name),
+		d.Help,
+		d.Labels,
+		nil,
+	)
+}
+
+// Exporter collects redis metrics. It can be registered once and collected
+// any number of times, concurrently.
 type Exporter struct {
-	ctx      context.Context
-	logger   log.Logger
-	opts     []*redis.Options
-	scrapers []Scraper
+	ctx       context.Context
+	logger    log.Logger
+	opts      []*redis.Options
+	scrapers  []Scraper
+	labels    prometheus.Labels
+	keyChecks *KeyCheckSettings
+	timeout   time.Duration
+	scheduler *Scheduler
+	breaker   *Breaker
+}
+
+// Option configures an Exporter.
+type Option func(*Exporter)
+
+// WithLogger sets the logger of the exporter, which logs nothing by default.
+func WithLogger(logger log.Logger) Option {
+	return func(e *Exporter) {
+		e.logger = logger
+	}
+}
+
+// WithLabels sets constant labels added to all the metrics of the exporter
+// when it is registered with Register.
+func WithLabels(labels prometheus.Labels) Option {
+	return func(e *Exporter) {
+		e.labels = labels
+	}
+}
+
+// WithKeyCheckSettings overrides the key sampling settings of the scrapers.
+func WithKeyCheckSettings(settings *KeyCheckSettings) Option {
+	return func(e *Exporter) {
+		e.keyChecks = settings
+	}
+}
+
+// WithTimeout sets the deadline of every scrape, 0 means no deadline.
+func WithTimeout(timeout time.Duration) Option {
+	return func(e *Exporter) {
+		e.timeout = timeout
+	}
+}
+
+// WithScheduler sets the scheduler bounding the concurrency of the scrapes,
+// which can be shared by several exporters. There is no limit by default.
+func WithScheduler(scheduler *Scheduler) Option {
+	return func(e *Exporter) {
+		e.scheduler = scheduler
+	}
+}
+
+// WithBreaker sets the circuit breaker skipping the unreachable nodes, which
+// can be shared by several exporters. There is no breaker by default.
+func WithBreaker(breaker *Breaker) Option {
+	return func(e *Exporter) {
+		e.breaker = breaker
+	}
+}
+
+// WithContext returns a copy of the exporter whose scrapes are bounded by ctx,
+// for request scoped deadlines and cancellation. The timeout of the exporter
+// still applies.
+func (e *Exporter) WithContext(ctx context.Context) *Exporter {
+	e2 := *e
+	e2.ctx = ctx
+	return &e2
 }
 
 // Collect implements prometheus.Collector.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	e.scrape(e.ctx, ch)
+	ctx := e.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	e.scrape(ctx, ch)
 	ch <- prometheus.MustNewConstMetric(redisUp, prometheus.CounterValue, 1)
 }
 
@@ -84,44 +212,278 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- redisScrapeDurationSeconds
 }
 
+// Register registers the exporter with reg, its constant labels are added to
+// every metric it collects.
+func (e *Exporter) Register(reg prometheus.Registerer) error {
+	if len(e.labels) > 0 {
+		reg = prometheus.WrapRegistererWith(e.labels, reg)
+	}
+	return reg.Register(e)
+}
+
 // *Exporter implements prometheus.Collector
 var _ prometheus.Collector = (*Exporter)(nil)
 
-func (e *Exporter) scrape(ctx context.Context, ch chan<- prometheus.Metric) float64 {
-	var rdbs []*redis.Client
+// scrapeResult is the outcome of a scraper on a single node.
+type scrapeResult struct {
+	scraper  Scraper
+	addr     string
+	metrics  []prometheus.Metric
+	err      error
+	start    time.Time
+	duration time.Duration
+	// started is set once the scraper got a slot from the scheduler.
+	started atomic.Bool
+}
+
+// nodeGate is the breaker decision of a node for a scrape.
+type nodeGate struct {
+	// done is set once the breaker allowed or skipped the node.
+	done     bool
+	skipped  bool
+	probeErr error
+	// abandoned is set when the scrape ended before the decision.
+	abandoned bool
+}
+
+// runScraper runs the scraper of res on a single node and records the
+// metrics it sent in res.
+func (e *Exporter) runScraper(ctx context.Context, res *scrapeResult, rdb *redis.Client) {
+	release, err := e.scheduler.acquire(ctx, res.addr)
+	if err != nil {
+		res.err = err
+		return
+	}
+	defer release()
+	res.started.Store(true)
+
+	metrics := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range metrics {
+			res.metrics = append(res.metrics, m)
+		}
+	}()
+
+	res.start = time.Now()
+	sc := &ScrapeContext{Clients: []*redis.Client{rdb}, KeyChecks: e.keyChecks}
+	res.err = res.scraper.Scrape(ctx, sc, metrics, log.With(e.logger, "scraper", res.scraper.Name(), "addr", res.addr))
+	res.duration = time.Since(res.start)
+	close(metrics)
+	<-done
+}
+
+// scrape runs every scraper on every node under the limits of the scheduler.
+// The metrics of the (node, scraper) pairs which haven't finished at the
+// deadline of ctx are dropped, the others are still exported. Nodes whose
+// circuit breaker is open are skipped, their last known metrics are exported
+// instead when the breaker keeps them.
+func (e *Exporter) scrape(ctx context.Context, ch chan<- prometheus.Metric) {
+	var clients []*redis.Client
 	for _, opt := range e.opts {
-		rdbs = append(rdbs, redis.NewClient(opt))
+		clients = append(clients, NewClient(opt))
 	}
+	defer func() {
+		for _, rdb := range clients {
+			rdb.Close()
+		}
+	}()
+
+	scrapeStart := time.Now()
+
+	var pairs []*scrapeResult
+	// gates hold the breaker decision of every node, guarded by gatesMu as
+	// a probe may still be running at the deadline.
+	gates := make(map[string]*nodeGate, len(clients))
+	var gatesMu sync.Mutex
 
-	var wg sync.WaitGroup
-	defer wg.Wait()
+	// Buffered so the scrapes still running at the deadline don't block.
+	results := make(chan *scrapeResult, len(clients)*len(e.scrapers))
+	for _, rdb := range clients {
+		var nodePairs []*scrapeResult
+		for _, scraper := range e.scrapers {
+			nodePairs = append(nodePairs, &scrapeResult{scraper: scraper, addr: rdb.Options().Addr})
+		}
+		pairs = append(pairs, nodePairs...)
 
+		gate := &nodeGate{}
+		gates[rdb.Options().Addr] = gate
+
+		go func(rdb *redis.Client, nodePairs []*scrapeResult, gate *nodeGate) {
+			if e.breaker != nil {
+				allowed, err := e.breaker.allow(ctx, rdb)
+
+				gatesMu.Lock()
+				gate.done, gate.skipped, gate.probeErr = true, !allowed, err
+				abandoned := gate.abandoned
+				gatesMu.Unlock()
+
+				if abandoned {
+					return
+				}
+				if !allowed {
+					for _, res := range nodePairs {
+						res.err = errNodeUnavailable
+						results <- res
+					}
+					return
+				}
+			}
+			for _, res := range nodePairs {
+				go func(res *scrapeResult) {
+					e.runScraper(ctx, res, rdb)
+					results <- res
+				}(res)
+			}
+		}(rdb, nodePairs, gate)
+	}
+
+	type stats struct {
+		pending int
+		success bool
+		end     time.Time
+	}
+	scraperStats := make(map[Scraper]*stats, len(e.scrapers))
 	for _, scraper := range e.scrapers {
-		wg.Add(1)
-		go func(scraper Scraper) {
-			defer wg.Done()
-
-			scrapeSuccess := 1.0
-			label := fmt.Sprintf("collect.%s", scraper.Name())
-			startTime := time.Now()
-			if err := scraper.Scrape(ctx, rdbs, ch, log.With(e.logger, "scraper", scraper.Name())); err != nil {
-				level.Error(e.logger).Log("msg", "Error from scraper", "scraper", scraper.Name(), "err", err)
-				scrapeSuccess = 0.0
+		scraperStats[scraper] = &stats{pending: len(clients), success: true, end: scrapeStart}
+	}
+	nodeStats := make(map[string]*stats, len(clients))
+	for _, rdb := range clients {
+		nodeStats[rdb.Options().Addr] = &stats{pending: len(e.scrapers), success: true, end: scrapeStart}
+	}
+	finish := func(st *stats, success bool, end time.Time) {
+		st.pending--
+		st.success = st.success && success
+		if end.After(st.end) {
+			st.end = end
+		}
+	}
+
+	finished := make(map[*scrapeResult]bool, len(pairs))
+	nodeDown := make(map[string]bool, len(clients))
+	nodeUp := make(map[string]bool, len(clients))
+
+	pending := len(pairs)
+	for ; pending > 0; pending-- {
+		var res *scrapeResult
+		select {
+		case res = <-results:
+		case <-ctx.Done():
+		}
+		if res == nil {
+			break
+		}
+		finished[res] = true
+
+		for _, m := range res.metrics {
+			ch <- m
+		}
+
+		end := res.start.Add(res.duration)
+		if res.err != nil {
+			if res.err != errNodeUnavailable {
+				level.Error(e.logger).Log("msg", "Error from scraper", "scraper", res.scraper.Name(), "addr", res.addr, "err", res.err)
+			}
+			end = time.Now()
+		}
+		if res.started.Load() && isNodeDown(res.err) {
+			nodeDown[res.addr] = true
+		}
+		if res.err == nil {
+			nodeUp[res.addr] = true
+		}
+		finish(scraperStats[res.scraper], res.err == nil, end)
+		finish(nodeStats[res.addr], res.err == nil, end)
+	}
+
+	if pending > 0 {
+		level.Warn(e.logger).Log("msg", "Scrape deadline exceeded, exporting partial results", "pending", pending, "err", ctx.Err())
+
+		// The pairs still running are failed at the deadline.
+		now := time.Now()
+		for _, st := range scraperStats {
+			if st.pending > 0 {
+				st.success, st.end = false, now
+			}
+		}
+		for _, st := range nodeStats {
+			if st.pending > 0 {
+				st.success, st.end = false, now
 			}
-			duration := time.Since(startTime).Seconds()
-			ch <- prometheus.MustNewConstMetric(redisScrapeDurationSeconds, prometheus.GaugeValue, duration, label)
-			ch <- prometheus.MustNewConstMetric(redisScrapeSuccess, prometheus.GaugeValue, scrapeSuccess, label)
-		}(scraper)
+		}
+		for _, res := range pairs {
+			if !finished[res] && res.started.Load() {
+				nodeDown[res.addr] = true
+			}
+		}
+	}
+
+	for scraper, st := range scraperStats {
+		label := fmt.Sprintf("collect.%s", scraper.Name())
+		ch <- prometheus.MustNewConstMetric(redisScrapeDurationSeconds, prometheus.GaugeValue, st.end.Sub(scrapeStart).Seconds(), label)
+		ch <- prometheus.MustNewConstMetric(redisScrapeSuccess, prometheus.GaugeValue, boolToFloat64(st.success), label)
+	}
+	for addr, st := range nodeStats {
+		ch <- prometheus.MustNewConstMetric(redisNodeScrapeDurationSeconds, prometheus.GaugeValue, st.end.Sub(scrapeStart).Seconds(), addr)
+		ch <- prometheus.MustNewConstMetric(redisNodeScrapeSuccess, prometheus.GaugeValue, boolToFloat64(st.success), addr)
+	}
+
+	if e.breaker == nil {
+		return
 	}
 
-	return 1.0
+	gatesMu.Lock()
+	defer gatesMu.Unlock()
+
+	stale := make(map[string]bool, len(clients))
+	for _, res := range pairs {
+		if finished[res] && res.err == nil {
+			e.breaker.store(res.addr, res.scraper.Name(), res.metrics)
+			continue
+		}
+		if finished[res] && len(res.metrics) > 0 {
+			// The partial results were exported already.
+			continue
+		}
+		for _, m := range e.breaker.stale(res.addr, res.scraper.Name()) {
+			ch <- m
+			stale[res.addr] = true
+		}
+	}
+	for _, rdb := range clients {
+		addr := rdb.Options().Addr
+		gate := gates[addr]
+		switch {
+		case !gate.done:
+			// The probe is still running at the deadline, the node hangs.
+			gate.abandoned = true
+			e.breaker.record(addr, true)
+		case gate.probeErr != nil:
+			e.breaker.record(addr, true)
+		case gate.skipped:
+		case nodeDown[addr]:
+			e.breaker.record(addr, true)
+		case nodeUp[addr]:
+			// A node none of whose scrapes finished, starved by the
+			// scheduler for instance, isn't known to be healthy.
+			e.breaker.record(addr, false)
+		}
+		e.breaker.collect(addr, ch)
+		ch <- prometheus.MustNewConstMetric(redisNodeStale, prometheus.GaugeValue, boolToFloat64(stale[addr]), addr)
+	}
 }
 
-func New(ctx context.Context, opts []*redis.Options, scrapers []Scraper, logger log.Logger) *Exporter {
-	return &Exporter{
-		ctx:      ctx,
-		logger:   logger,
-		opts:     opts,
-		scrapers: scrapers,
+// New returns an exporter running scrapers on the nodes of opts.
+func New(opts []*redis.Options, scrapers []Scraper, options ...Option) *Exporter {
+	e := &Exporter{
+		logger:    log.NewNopLogger(),
+		opts:      opts,
+		scrapers:  scrapers,
+		scheduler: NewScheduler(0, 0),
+	}
+	for _, option := range options {
+		option(e)
 	}
+	return e
 }
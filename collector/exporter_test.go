@@ -0,0 +1,366 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
+	"github.com/xieyanke/redis_exporter/internal/redistest"
+)
+
+// fakeRedis is a RESP2 server answering PING and INFO with fixed sections.
+// The INFO sections listed in hang, and PING if "ping" is, get no reply until
+// the test ends.
+type fakeRedis struct {
+	*redistest.Server
+	addr string
+	info map[string]string
+
+	mu   sync.Mutex
+	hang map[string]bool
+}
+
+func newFakeRedis(t *testing.T, info map[string]string) *fakeRedis {
+	t.Helper()
+
+	f := &fakeRedis{info: info}
+	f.Server = redistest.NewServer(t, f.reply)
+	f.addr = f.Addr
+	return f
+}
+
+func (f *fakeRedis) setHang(hang map[string]bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hang = hang
+}
+
+// wait blocks until the test ends if name hangs.
+func (f *fakeRedis) wait(name string) {
+	f.mu.Lock()
+	hang := f.hang[name]
+	f.mu.Unlock()
+	if hang {
+		<-f.Done
+	}
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		f.wait("ping")
+		return redistest.Status("PONG")
+	case "CLIENT", "SELECT":
+		return redistest.Status("OK")
+	case "INFO":
+		var section string
+		if len(args) > 1 {
+			section = strings.ToLower(args[1])
+		}
+		f.wait(section)
+		return redistest.Bulk(f.info[section])
+	}
+	return ""
+}
+
+func gatherValues(t *testing.T, e *Exporter) map[string]float64 {
+	t.Helper()
+
+	values := make(map[string]float64)
+	for _, mf := range gather(t, e) {
+		for _, m := range mf.GetMetric() {
+			name := mf.GetName()
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "collector" {
+					name += "/" + l.GetValue()
+				}
+			}
+			values[name] = m.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func gather(t *testing.T, e *Exporter) []*dto.MetricFamily {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	if err := e.Register(registry); err != nil {
+		t.Error(err)
+		return nil
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Error(err)
+	}
+	return mfs
+}
+
+func gatherNames(t *testing.T, e *Exporter) map[string]bool {
+	t.Helper()
+
+	mfs := gather(t, e)
+	names := make(map[string]bool, len(mfs))
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	return names
+}
+
+// TestExporterConcurrentScrapes scrapes nodes with different keyspace and
+// commandstats sections in parallel with shared scrapers, each scrape must
+// only see the metrics of its own node. Run with -race.
+func TestExporterConcurrentScrapes(t *testing.T) {
+	nodes := []struct {
+		redis  *fakeRedis
+		want   []string
+		absent []string
+	}{
+		{
+			redis: newFakeRedis(t, map[string]string{
+				"keyspace":     "# Keyspace\r\ndb0:keys=10,expires=1,avg_ttl=100",
+				"commandstats": "# Commandstats\r\ncmdstat_get:calls=5,usec=10,usec_per_call=2.00",
+			}),
+			want:   []string{"redis_server_keyspace_db0_keys_in_total", "redis_server_cmdstat_get_calls"},
+			absent: []string{"redis_server_keyspace_db1_keys_in_total", "redis_server_cmdstat_set_calls"},
+		},
+		{
+			redis: newFakeRedis(t, map[string]string{
+				"keyspace":     "# Keyspace\r\ndb0:keys=3,expires=0,avg_ttl=0\r\ndb1:keys=7,expires=2,avg_ttl=50",
+				"commandstats": "# Commandstats\r\ncmdstat_set:calls=8,usec=16,usec_per_call=2.00",
+			}),
+			want:   []string{"redis_server_keyspace_db0_keys_in_total", "redis_server_keyspace_db1_keys_in_total", "redis_server_cmdstat_set_calls"},
+			absent: []string{"redis_server_cmdstat_get_calls"},
+		},
+	}
+
+	scrapers := []Scraper{NewInfoKeyspaceScraper(), NewInfoCommandStatsScraper()}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		node := nodes[i%len(nodes)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				opts := []*redis.Options{{Addr: node.redis.addr}}
+				e := New(opts, scrapers,
+					WithLabels(prometheus.Labels{"target": node.redis.addr}),
+					WithTimeout(5*time.Second),
+				)
+
+				names := gatherNames(t, e)
+				for _, name := range node.want {
+					if !names[name] {
+						t.Errorf("%s: missing metric %s", node.redis.addr, name)
+					}
+				}
+				for _, name := range node.absent {
+					if names[name] {
+						t.Errorf("%s: unexpected metric %s", node.redis.addr, name)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestExporterDeadline checks that a node hanging on a scraper doesn't hold
+// the scrape past its deadline, and that the finished scrapers are exported.
+func TestExporterDeadline(t *testing.T) {
+	node := newFakeRedis(t, map[string]string{
+		"keyspace": "# Keyspace\r\ndb0:keys=10,expires=1,avg_ttl=100",
+	})
+	node.setHang(map[string]bool{"commandstats": true})
+
+	e := New([]*redis.Options{{Addr: node.addr}},
+		[]Scraper{NewInfoKeyspaceScraper(), NewInfoCommandStatsScraper()},
+		WithTimeout(200*time.Millisecond),
+		WithScheduler(NewScheduler(4, 2)),
+	)
+
+	start := time.Now()
+	values := gatherValues(t, e)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("scrape took %s, past its deadline", elapsed)
+	}
+
+	for name, want := range map[string]float64{
+		"redis_server_keyspace_db0_keys_in_total":                 10,
+		"redis_exporter_scrape_success/collect.info.keyspace":     1,
+		"redis_exporter_scrape_success/collect.info.commandstats": 0,
+		"redis_exporter_node_scrape_success":                      0,
+	} {
+		if got, ok := values[name]; !ok || got != want {
+			t.Errorf("%s = %v (found %v), want %v", name, got, ok, want)
+		}
+	}
+}
+
+// TestExporterBreaker checks that a node going down, or hanging, opens its
+// breaker with a growing backoff, and that its last known metrics are exported
+// while it is skipped.
+func TestExporterBreaker(t *testing.T) {
+	type step struct {
+		// wait before the scrape, for the backoff to elapse.
+		wait time.Duration
+		want map[string]float64
+	}
+	tests := []struct {
+		name       string
+		minBackoff time.Duration
+		down       func(f *fakeRedis)
+		steps      []step
+	}{
+		{
+			name:       "closed",
+			minBackoff: time.Minute,
+			down:       func(f *fakeRedis) { f.Close() },
+			steps: []step{
+				{want: map[string]float64{"redis_exporter_node_breaker_open": 0, "redis_exporter_node_stale": 0}},
+				// The node is down, the scrape fails and opens the breaker.
+				{want: map[string]float64{"redis_exporter_node_breaker_open": 1, "redis_exporter_node_stale": 1, "redis_exporter_node_breaker_failures": 1}},
+				// The node is skipped until the backoff elapses.
+				{want: map[string]float64{"redis_exporter_node_breaker_open": 1, "redis_exporter_node_stale": 1, "redis_exporter_node_breaker_failures": 1}},
+			},
+		},
+		{
+			name:       "hanging",
+			minBackoff: 50 * time.Millisecond,
+			down:       func(f *fakeRedis) { f.setHang(map[string]bool{"ping": true, "keyspace": true}) },
+			steps: []step{
+				{want: map[string]float64{"redis_exporter_node_breaker_open": 0, "redis_exporter_node_stale": 0}},
+				// The scrape hangs until the deadline and opens the breaker.
+				{want: map[string]float64{"redis_exporter_node_breaker_open": 1, "redis_exporter_node_stale": 1, "redis_exporter_node_breaker_failures": 1}},
+				// The probe hangs until the deadline, which is a failure too.
+				{wait: 100 * time.Millisecond, want: map[string]float64{"redis_exporter_node_breaker_open": 1, "redis_exporter_node_stale": 1, "redis_exporter_node_breaker_failures": 2}},
+				{wait: 150 * time.Millisecond, want: map[string]float64{"redis_exporter_node_breaker_open": 1, "redis_exporter_node_stale": 1, "redis_exporter_node_breaker_failures": 3}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := newFakeRedis(t, map[string]string{
+				"keyspace": "# Keyspace\r\ndb0:keys=10,expires=1,avg_ttl=100",
+			})
+
+			e := New([]*redis.Options{{Addr: node.addr}},
+				[]Scraper{NewInfoKeyspaceScraper()},
+				WithTimeout(200*time.Millisecond),
+				WithBreaker(NewBreaker(tt.minBackoff, time.Hour, time.Hour)),
+			)
+
+			for i, step := range tt.steps {
+				if i == 1 {
+					tt.down(node)
+				}
+				time.Sleep(step.wait)
+
+				values := gatherValues(t, e)
+				step.want["redis_server_keyspace_db0_keys_in_total"] = 10
+				for name, v := range step.want {
+					if got, ok := values[name]; !ok || got != v {
+						t.Errorf("step %d: %s = %v (found %v), want %v", i, name, got, ok, v)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestReservedLabels checks that the variable labels of every metric, those of
+// the exporter in the parent directory included, are reserved so that no
+// constant label can clash with them. The label names are read from the
+// []string literals given to prometheus.NewDesc and MetricDesc.Labels.
+func TestReservedLabels(t *testing.T) {
+	labels := map[string]string{}
+	for _, dir := range []string{".", ".."} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, path, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ast.Inspect(file, func(n ast.Node) bool {
+				var expr ast.Expr
+				switch n := n.(type) {
+				case *ast.CallExpr:
+					sel, ok := n.Fun.(*ast.SelectorExpr)
+					if !ok {
+						return true
+					}
+					switch sel.Sel.Name {
+					case "NewDesc":
+						if len(n.Args) > 2 {
+							expr = n.Args[2]
+						}
+					case "NewConstHistogram", "MustNewConstHistogram":
+						labels["le"] = fset.Position(n.Pos()).String()
+					}
+				case *ast.KeyValueExpr:
+					if key, ok := n.Key.(*ast.Ident); ok && key.Name == "Labels" {
+						expr = n.Value
+					}
+				}
+				lit, ok := expr.(*ast.CompositeLit)
+				if !ok {
+					return true
+				}
+				for _, elt := range lit.Elts {
+					if s, ok := elt.(*ast.BasicLit); ok && s.Kind == token.STRING {
+						label, _ := strconv.Unquote(s.Value)
+						labels[label] = fset.Position(s.Pos()).String()
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	if len(labels) == 0 {
+		t.Fatal("no variable label found")
+	}
+	for label, pos := range labels {
+		if !reservedLabels[label] {
+			t.Errorf("%s: variable label %q isn't reserved", pos, label)
+		}
+	}
+}
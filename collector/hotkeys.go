@@ -0,0 +1,251 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// HotKeysSettings configures the hotkeys scraper, zero values use the defaults.
+type HotKeysSettings struct {
+	// SampleSize is the number of keys sampled per db on each scrape. Defaults
+	// to 1000.
+	SampleSize int
+	// SampleMethod is how keys are sampled, either randomkey or scan. Defaults
+	// to randomkey.
+	SampleMethod string
+	// TopN is the number of hottest keys exported per db, and of hottest slots
+	// per node in cluster mode. Defaults to 10.
+	TopN int
+	// ConfigCommand is the name of the CONFIG command, for servers where it has
+	// been renamed. Defaults to CONFIG.
+	ConfigCommand string
+}
+
+var (
+	hotKeyLFUFreq = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "hotkey", "lfu_freq"),
+		"LFU access frequency counter of the hottest sampled keys.",
+		[]string{"addr", "db", "key"},
+		nil,
+	)
+	hotKeySlotLFUFreq = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "hotkey", "slot_lfu_freq"),
+		"Sum of the LFU access frequency counters of the sampled keys of the hottest cluster slots.",
+		[]string{"addr", "slot"},
+		nil,
+	)
+)
+
+type hotKey struct {
+	key  string
+	freq float64
+}
+
+type hotKeysScraper struct {
+	settings HotKeysSettings
+	cursors  *scanCursors
+}
+
+func NewHotKeysScraper(settings HotKeysSettings) *hotKeysScraper {
+	settings.SampleSize = intSetting(settings.SampleSize, 1000)
+	settings.SampleMethod = stringSetting(settings.SampleMethod, sampleMethodRandomKey)
+	settings.TopN = intSetting(settings.TopN, 10)
+	settings.ConfigCommand = stringSetting(settings.ConfigCommand, defaultConfigCommand)
+
+	return &hotKeysScraper{
+		settings: settings,
+		cursors:  newScanCursors(),
+	}
+}
+
+// isLFUPolicy reports whether OBJECT FREQ is usable under the maxmemory policy.
+func isLFUPolicy(policy string) bool {
+	return strings.HasSuffix(policy, "-lfu")
+}
+
+func isRedisClusterEnabled(ctx context.Context, rdb *redis.Client) (bool, error) {
+	section, err := rdb.Info(ctx, "cluster").Result()
+	if err != nil {
+		return false, err
+	}
+	return parseRedisInfoResp(section)["cluster_enabled"] == "1", nil
+}
+
+func (scraper *hotKeysScraper) sampleFreqs(ctx context.Context, rdb *redis.Client, settings *KeyCheckSettings) ([]*hotKey, error) {
+	keys, err := scraper.cursors.sampleKeys(ctx, rdb,
+		stringSetting(settings.SampleMethod, scraper.settings.SampleMethod),
+		intSetting(settings.SampleSize, scraper.settings.SampleSize),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := rdb.Pipeline()
+	seen := make(map[string]bool, len(keys))
+	var sampled []string
+	var cmds []*redis.Cmd
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		sampled = append(sampled, key)
+		cmds = append(cmds, pipe.Do(ctx, "OBJECT", "FREQ", key))
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	hotKeys := make([]*hotKey, 0, len(sampled))
+	for i, key := range sampled {
+		if freq, err := cmds[i].Int64(); err == nil {
+			hotKeys = append(hotKeys, &hotKey{key: key, freq: float64(freq)})
+		}
+	}
+	sort.Slice(hotKeys, func(i, j int) bool {
+		if hotKeys[i].freq != hotKeys[j].freq {
+			return hotKeys[i].freq > hotKeys[j].freq
+		}
+		return hotKeys[i].key < hotKeys[j].key
+	})
+
+	return hotKeys, nil
+}
+
+// Scrape implements Scraper.
+func (scraper *hotKeysScraper) Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error {
+	var err error
+
+	settings := sc.keyChecks()
+	topN := intSetting(settings.TopN, scraper.settings.TopN)
+
+	for _, rdb := range sc.Clients {
+		addr := rdb.Options().Addr
+
+		var m map[string]string
+		m, err = getRedisConfig(ctx, rdb, scraper.settings.ConfigCommand, []string{"maxmemory-policy"})
+		if err != nil {
+			return err
+		}
+		if policy := m["maxmemory-policy"]; !isLFUPolicy(policy) {
+			level.Warn(logger).Log("msg", fmt.Sprintf("hot key sampling skipped on %s, maxmemory-policy is not lfu", addr), "policy", policy)
+			continue
+		}
+
+		var clusterEnabled bool
+		clusterEnabled, err = isRedisClusterEnabled(ctx, rdb)
+		if err != nil {
+			return err
+		}
+
+		var dbs map[int]int64
+		dbs, err = GetRedisKeyspaceDBs(ctx, rdb)
+		if err != nil {
+			return err
+		}
+
+		slotFreqs := make(map[int]float64)
+		for db := range dbs {
+			dbRdb := newDBClient(rdb, db)
+
+			var hotKeys []*hotKey
+			hotKeys, err = scraper.sampleFreqs(ctx, dbRdb, settings)
+			dbRdb.Close()
+			if err != nil {
+				return err
+			}
+
+			dbLabel := strconv.Itoa(db)
+			for i, k := range hotKeys {
+				if clusterEnabled {
+					slotFreqs[redisClusterKeySlot(k.key)] += k.freq
+				}
+				if i < topN {
+					ch <- prometheus.MustNewConstMetric(hotKeyLFUFreq, prometheus.GaugeValue, k.freq, addr, dbLabel, k.key)
+				}
+			}
+		}
+
+		slots := make([]int, 0, len(slotFreqs))
+		for slot := range slotFreqs {
+			slots = append(slots, slot)
+		}
+		sort.Slice(slots, func(i, j int) bool {
+			if slotFreqs[slots[i]] != slotFreqs[slots[j]] {
+				return slotFreqs[slots[i]] > slotFreqs[slots[j]]
+			}
+			return slots[i] < slots[j]
+		})
+		if len(slots) > topN {
+			slots = slots[:topN]
+		}
+		for _, slot := range slots {
+			ch <- prometheus.MustNewConstMetric(hotKeySlotLFUFreq, prometheus.GaugeValue, slotFreqs[slot], addr, strconv.Itoa(slot))
+		}
+	}
+
+	return err
+}
+
+// Forget implements NodeStateScraper.
+func (scraper *hotKeysScraper) Forget(addr string) {
+	scraper.cursors.forget(addr)
+}
+
+// Help implements Scraper.
+func (*hotKeysScraper) Help() string {
+	return "Collect the hottest sampled keys by LFU frequency from each redis node with an lfu maxmemory-policy."
+}
+
+// Name implements Scraper.
+func (*hotKeysScraper) Name() string {
+	return "hotkeys"
+}
+
+// Version implements Scraper.
+func (*hotKeysScraper) Version() string {
+	return "4.0"
+}
+
+// Commands implements CommandsScraper.
+func (scraper *hotKeysScraper) Commands() [][]interface{} {
+	return [][]interface{}{
+		{scraper.settings.ConfigCommand, "get", "maxmemory-policy"},
+		{"info", "cluster"},
+		{"info", "keyspace"},
+		{"select", "1"},
+		{"randomkey"},
+		{"scan", "0"},
+		{"object", "freq", aclCheckKey},
+	}
+}
+
+var _ CommandsScraper = &hotKeysScraper{}
+var _ NodeStateScraper = &hotKeysScraper{}
@@ -0,0 +1,219 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
+	"github.com/xieyanke/redis_exporter/internal/redistest"
+)
+
+func TestIsLFUPolicy(t *testing.T) {
+	tests := map[string]bool{
+		"allkeys-lfu":  true,
+		"volatile-lfu": true,
+		"allkeys-lru":  false,
+		"volatile-ttl": false,
+		"noeviction":   false,
+		"":             false,
+	}
+	for policy, want := range tests {
+		if got := isLFUPolicy(policy); got != want {
+			t.Errorf("isLFUPolicy(%q) = %v, want %v", policy, got, want)
+		}
+	}
+}
+
+// hotKeysServer is a RESP2 server with the given maxmemory-policy and the LFU
+// frequency of its keys, sampled with RANDOMKEY.
+type hotKeysServer struct {
+	*redistest.Server
+	policy  string
+	cluster bool
+	keys    []string
+	freqs   map[string]int64
+
+	mu       sync.Mutex
+	next     int
+	commands []string
+}
+
+func newHotKeysServer(t *testing.T, policy string, cluster bool, freqs map[string]int64, keys []string) *hotKeysServer {
+	t.Helper()
+
+	s := &hotKeysServer{policy: policy, cluster: cluster, keys: keys, freqs: freqs}
+	s.Server = redistest.NewServer(t, s.reply)
+	return s
+}
+
+func (s *hotKeysServer) reply(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, strings.ToUpper(args[0]))
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return redistest.Status("PONG")
+	case "CLIENT", "SELECT":
+		return redistest.Status("OK")
+	case "CONFIG":
+		return redistest.Array(redistest.Bulk("maxmemory-policy"), redistest.Bulk(s.policy))
+	case "INFO":
+		if strings.ToLower(args[1]) == "cluster" {
+			enabled := "0"
+			if s.cluster {
+				enabled = "1"
+			}
+			return redistest.Info("# Cluster", "cluster_enabled:"+enabled)
+		}
+		return redistest.Info("# Keyspace", "db0:keys="+strconv.Itoa(len(s.keys))+",expires=0,avg_ttl=0")
+	case "RANDOMKEY":
+		key := s.keys[s.next%len(s.keys)]
+		s.next++
+		return redistest.Bulk(key)
+	case "OBJECT":
+		freq, ok := s.freqs[args[2]]
+		if !ok {
+			return redistest.Nil()
+		}
+		return redistest.Int(freq)
+	}
+	return ""
+}
+
+func (s *hotKeysServer) ran(command string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+// scrapeHotKeys returns the frequency of the exported keys and slots.
+func scrapeHotKeys(t *testing.T, addr string, settings *KeyCheckSettings) (keys, slots map[string]float64) {
+	t.Helper()
+
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	defer rdb.Close()
+
+	ch := make(chan prometheus.Metric, 100)
+	sc := &ScrapeContext{Clients: []*redis.Client{rdb}, KeyChecks: settings}
+	if err := NewHotKeysScraper(HotKeysSettings{}).Scrape(context.Background(), sc, ch, log.NewNopLogger()); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	keys, slots = map[string]float64{}, map[string]float64{}
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := map[string]string{}
+		for _, l := range pb.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		switch m.Desc() {
+		case hotKeyLFUFreq:
+			keys[labels["key"]] = pb.GetGauge().GetValue()
+		case hotKeySlotLFUFreq:
+			slots[labels["slot"]] = pb.GetGauge().GetValue()
+		}
+	}
+	return keys, slots
+}
+
+// TestHotKeysScrapeNotLFU checks that no key is sampled without an lfu policy,
+// OBJECT FREQ fails under the other ones.
+func TestHotKeysScrapeNotLFU(t *testing.T) {
+	s := newHotKeysServer(t, "allkeys-lru", false, map[string]int64{"a": 10}, []string{"a"})
+
+	keys, slots := scrapeHotKeys(t, s.Addr, &KeyCheckSettings{SampleSize: 1})
+	if len(keys) != 0 || len(slots) != 0 {
+		t.Errorf("keys = %v, slots = %v, want none", keys, slots)
+	}
+	for _, command := range []string{"RANDOMKEY", "SCAN", "OBJECT"} {
+		if s.ran(command) {
+			t.Errorf("%s ran without an lfu policy", command)
+		}
+	}
+}
+
+// TestHotKeysScrape checks the top-n keys by frequency, and the per slot rollup
+// of the sampled keys in cluster mode.
+func TestHotKeysScrape(t *testing.T) {
+	freqs := map[string]int64{
+		"{user:1}:profile": 50,
+		"{user:1}:cart":    40,
+		"session:9":        60,
+		"page:home":        5,
+	}
+	// "gone" expires between RANDOMKEY and OBJECT FREQ.
+	sampled := []string{"{user:1}:profile", "{user:1}:cart", "session:9", "page:home", "gone", "session:9"}
+
+	slot := func(key string) string {
+		return strconv.Itoa(redisClusterKeySlot(key))
+	}
+
+	tests := []struct {
+		name      string
+		cluster   bool
+		wantKeys  map[string]float64
+		wantSlots map[string]float64
+	}{
+		{
+			name:      "standalone",
+			wantKeys:  map[string]float64{"session:9": 60, "{user:1}:profile": 50},
+			wantSlots: map[string]float64{},
+		},
+		{
+			name:     "cluster",
+			cluster:  true,
+			wantKeys: map[string]float64{"session:9": 60, "{user:1}:profile": 50},
+			wantSlots: map[string]float64{
+				slot("{user:1}:profile"): 90,
+				slot("session:9"):        60,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newHotKeysServer(t, "allkeys-lfu", tt.cluster, freqs, sampled)
+
+			keys, slots := scrapeHotKeys(t, s.Addr, &KeyCheckSettings{SampleSize: len(sampled), TopN: 2})
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("keys = %v, want %v", keys, tt.wantKeys)
+			}
+			if !reflect.DeepEqual(slots, tt.wantSlots) {
+				t.Errorf("slots = %v, want %v", slots, tt.wantSlots)
+			}
+		})
+	}
+}
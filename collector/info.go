@@ -7,7 +7,6 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
-	redis "github.com/redis/go-redis/v9"
 )
 
 type infoScraper struct {
@@ -32,10 +31,10 @@ func (*infoScraper) Version() string {
 }
 
 // Scrape implements Scraper.
-func (scraper *infoScraper) Scrape(ctx context.Context, rdbs []*redis.Client, ch chan<- prometheus.Metric, logger log.Logger) error {
+func (scraper *infoScraper) Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error {
 	var err error
 
-	for _, rdb := range rdbs {
+	for _, rdb := range sc.Clients {
 		addr := rdb.Options().Addr
 
 		var sectionRes string
@@ -45,32 +44,27 @@ func (scraper *infoScraper) Scrape(ctx context.Context, rdbs []*redis.Client, ch
 		}
 		var sectionMap map[string]string
 
+		// The descriptors of the keyspace and commandstats sections depend on
+		// the reply, they are local to the scrape as the scraper is shared.
+		metricsDesc := scraper.metricsDesc
 		switch scraper.section {
 		case "keyspace":
 			sectionMap = parseRedisInfoKeyspaceOrCmdtatsResp(sectionRes)
-			metricsDesc := initKeyspaceMetricsDesc(sectionMap)
-			scraper.metricsDesc = metricsDesc
+			metricsDesc = initKeyspaceMetricsDesc(sectionMap)
 		case "commandstats":
 			sectionMap = parseRedisInfoKeyspaceOrCmdtatsResp(sectionRes)
-			metricsDesc := initCmdStatsMetricsDesc(sectionMap)
-			scraper.metricsDesc = metricsDesc
+			metricsDesc = initCmdStatsMetricsDesc(sectionMap)
 		default:
 			sectionMap = parseRedisInfoResp(sectionRes)
 		}
 
-		for k, v := range scraper.metricsDesc {
+		for k, v := range metricsDesc {
 			var f64 float64
 			f64, err = strconv.ParseFloat(sectionMap[k], 64)
 			checkParseRedisInfoRespError(k, addr, err, logger)
 
-			desc := prometheus.NewDesc(
-				prometheus.BuildFQName(Namespace, v.Subsystem, v.Name),
-				v.Help,
-				v.Labels,
-				nil,
-			)
 			ch <- prometheus.MustNewConstMetric(
-				desc,
+				v.Desc(),
 				prometheus.GaugeValue,
 				f64,
 				addr,
@@ -81,4 +75,11 @@ func (scraper *infoScraper) Scrape(ctx context.Context, rdbs []*redis.Client, ch
 	return err
 }
 
-var _ Scraper = &infoScraper{}
+// Commands implements CommandsScraper.
+func (scraper *infoScraper) Commands() [][]interface{} {
+	return [][]interface{}{
+		{"info", scraper.section},
+	}
+}
+
+var _ CommandsScraper = &infoScraper{}
@@ -0,0 +1,143 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"sync"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+const (
+	sampleMethodRandomKey = "randomkey"
+	sampleMethodScan      = "scan"
+)
+
+// KeyCheckSettings overrides the key sampling settings of the keyspace.prefix,
+// keys.ttl and hotkeys scrapers for the nodes of a target. Zero values keep
+// the settings of the scraper.
+type KeyCheckSettings struct {
+	SampleSize      int
+	SampleMethod    string
+	TopN            int
+	PrefixDelimiter string
+	PrefixDepth     int
+	PrefixRegex     string
+	MaxPrefixes     int
+}
+
+func intSetting(v, def int) int {
+	if v != 0 {
+		return v
+	}
+	return def
+}
+
+func stringSetting(v, def string) string {
+	if v != "" {
+		return v
+	}
+	return def
+}
+
+// sampleRandomKeys returns up to n keys of the selected db picked with RANDOMKEY.
+// The same key may be returned more than once.
+func sampleRandomKeys(ctx context.Context, rdb *redis.Client, n int) ([]string, error) {
+	pipe := rdb.Pipeline()
+	cmds := make([]*redis.StringCmd, n)
+	for i := range cmds {
+		cmds[i] = pipe.RandomKey(ctx)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, n)
+	for _, cmd := range cmds {
+		if key, err := cmd.Result(); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+type scanCursorKey struct {
+	addr string
+	db   int
+}
+
+// scanCursors remembers where the last SCAN sample of each node and db stopped,
+// so consecutive samples walk the whole keyspace instead of its first keys.
+type scanCursors struct {
+	mu      sync.Mutex
+	cursors map[scanCursorKey]uint64
+}
+
+func newScanCursors() *scanCursors {
+	return &scanCursors{cursors: make(map[scanCursorKey]uint64)}
+}
+
+// forget drops the cursors of a node which isn't scraped anymore.
+func (c *scanCursors) forget(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.cursors {
+		if k.addr == addr {
+			delete(c.cursors, k)
+		}
+	}
+}
+
+// sampleScanKeys returns up to n keys of the selected db with SCAN, resuming from
+// the cursor left by the previous sample.
+func (c *scanCursors) sampleScanKeys(ctx context.Context, rdb *redis.Client, n int) ([]string, error) {
+	k := scanCursorKey{addr: rdb.Options().Addr, db: rdb.Options().DB}
+	c.mu.Lock()
+	cursor := c.cursors[k]
+	c.mu.Unlock()
+
+	keys := make([]string, 0, n)
+	for len(keys) < n {
+		batch, next, err := rdb.Scan(ctx, cursor, "", int64(n-len(keys))).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	c.mu.Lock()
+	c.cursors[k] = cursor
+	c.mu.Unlock()
+
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys, nil
+}
+
+// sampleKeys returns up to n keys of the selected db with the given sample method.
+func (c *scanCursors) sampleKeys(ctx context.Context, rdb *redis.Client, method string, n int) ([]string, error) {
+	if method == sampleMethodScan {
+		return c.sampleScanKeys(ctx, rdb, n)
+	}
+	return sampleRandomKeys(ctx, rdb, n)
+}
@@ -0,0 +1,35 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScanCursorsForget(t *testing.T) {
+	c := newScanCursors()
+	c.cursors[scanCursorKey{addr: "10.0.0.1:6379", db: 0}] = 5
+	c.cursors[scanCursorKey{addr: "10.0.0.1:6379", db: 1}] = 7
+	c.cursors[scanCursorKey{addr: "10.0.0.2:6379", db: 0}] = 9
+
+	c.forget("10.0.0.1:6379")
+	want := map[scanCursorKey]uint64{{addr: "10.0.0.2:6379", db: 0}: 9}
+	if !reflect.DeepEqual(c.cursors, want) {
+		t.Errorf("cursors = %v, want %v", c.cursors, want)
+	}
+}
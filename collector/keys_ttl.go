@@ -0,0 +1,205 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// DefaultKeysTTLBuckets are the default upper bounds in seconds of the key ttl
+// histogram buckets.
+var DefaultKeysTTLBuckets = []float64{60, 300, 900, 3600, 21600, 86400, 604800}
+
+// KeysTTLSettings configures the keys.ttl scraper, zero values use the defaults.
+type KeysTTLSettings struct {
+	// SampleSize is the number of keys sampled per db on each scrape. Defaults
+	// to 1000.
+	SampleSize int
+	// SampleMethod is how keys are sampled, either randomkey or scan. Defaults
+	// to randomkey.
+	SampleMethod string
+	// Buckets are the upper bounds in seconds of the key ttl histogram buckets.
+	// Defaults to DefaultKeysTTLBuckets.
+	Buckets []float64
+}
+
+var (
+	keyTTLSeconds = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "key", "ttl_seconds"),
+		"Distribution of the ttl of the sampled keys which have one.",
+		[]string{"addr", "db"},
+		nil,
+	)
+	keyNoTTLKeys = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "key", "no_ttl_keys"),
+		"Number of sampled keys without a ttl.",
+		[]string{"addr", "db"},
+		nil,
+	)
+)
+
+type keysTTLScraper struct {
+	settings KeysTTLSettings
+	cursors  *scanCursors
+}
+
+func NewKeysTTLScraper(settings KeysTTLSettings) *keysTTLScraper {
+	settings.SampleSize = intSetting(settings.SampleSize, 1000)
+	settings.SampleMethod = stringSetting(settings.SampleMethod, sampleMethodRandomKey)
+	if len(settings.Buckets) == 0 {
+		settings.Buckets = DefaultKeysTTLBuckets
+	}
+	settings.Buckets = append([]float64(nil), settings.Buckets...)
+	sort.Float64s(settings.Buckets)
+
+	return &keysTTLScraper{
+		settings: settings,
+		cursors:  newScanCursors(),
+	}
+}
+
+// ParseHistogramBuckets parses a comma separated list of histogram bucket upper
+// bounds, in increasing order.
+func ParseHistogramBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		f64, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid histogram bucket %q: %w", item, err)
+		}
+		buckets = append(buckets, f64)
+	}
+	sort.Float64s(buckets)
+	return buckets, nil
+}
+
+// Scrape implements Scraper.
+func (scraper *keysTTLScraper) Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error {
+	settings := sc.keyChecks()
+	buckets := scraper.settings.Buckets
+
+	var err error
+
+	for _, rdb := range sc.Clients {
+		addr := rdb.Options().Addr
+
+		var dbs map[int]int64
+		dbs, err = GetRedisKeyspaceDBs(ctx, rdb)
+		if err != nil {
+			return err
+		}
+
+		for db := range dbs {
+			dbRdb := newDBClient(rdb, db)
+
+			var keys []string
+			var cmds []*redis.DurationCmd
+			keys, err = scraper.cursors.sampleKeys(ctx, dbRdb,
+				stringSetting(settings.SampleMethod, scraper.settings.SampleMethod),
+				intSetting(settings.SampleSize, scraper.settings.SampleSize),
+			)
+			if err == nil {
+				pipe := dbRdb.Pipeline()
+				for _, key := range keys {
+					cmds = append(cmds, pipe.PTTL(ctx, key))
+				}
+				if _, err = pipe.Exec(ctx); err == redis.Nil {
+					err = nil
+				}
+			}
+			dbRdb.Close()
+			if err != nil {
+				return err
+			}
+
+			var count uint64
+			var sum, noTTL float64
+			counts := make(map[float64]uint64, len(buckets))
+			for _, cmd := range cmds {
+				ttl, err := cmd.Result()
+				if err != nil {
+					continue
+				}
+				switch {
+				case ttl == -1:
+					noTTL++
+				case ttl >= 0:
+					seconds := ttl.Seconds()
+					count++
+					sum += seconds
+					for _, bucket := range buckets {
+						if seconds <= bucket {
+							counts[bucket]++
+						}
+					}
+				}
+			}
+
+			dbLabel := strconv.Itoa(db)
+			ch <- prometheus.MustNewConstHistogram(keyTTLSeconds, count, sum, counts, addr, dbLabel)
+			ch <- prometheus.MustNewConstMetric(keyNoTTLKeys, prometheus.GaugeValue, noTTL, addr, dbLabel)
+		}
+	}
+
+	return err
+}
+
+// Forget implements NodeStateScraper.
+func (scraper *keysTTLScraper) Forget(addr string) {
+	scraper.cursors.forget(addr)
+}
+
+// Help implements Scraper.
+func (*keysTTLScraper) Help() string {
+	return "Collect the ttl distribution of sampled keys from each redis node."
+}
+
+// Name implements Scraper.
+func (*keysTTLScraper) Name() string {
+	return "keys.ttl"
+}
+
+// Version implements Scraper.
+func (*keysTTLScraper) Version() string {
+	return "2.6"
+}
+
+// Commands implements CommandsScraper.
+func (*keysTTLScraper) Commands() [][]interface{} {
+	return [][]interface{}{
+		{"info", "keyspace"},
+		{"select", "1"},
+		{"randomkey"},
+		{"scan", "0"},
+		{"pttl", aclCheckKey},
+	}
+}
+
+var _ CommandsScraper = &keysTTLScraper{}
+var _ NodeStateScraper = &keysTTLScraper{}
@@ -0,0 +1,133 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
+	"github.com/xieyanke/redis_exporter/internal/redistest"
+)
+
+func TestParseHistogramBuckets(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []float64
+		wantErr bool
+	}{
+		{s: "60,300,900", want: []float64{60, 300, 900}},
+		{s: " 3600, 60 ,, 0.5 ", want: []float64{0.5, 60, 3600}},
+		{s: ""},
+		{s: "60,1h", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHistogramBuckets(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseHistogramBuckets(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseHistogramBuckets(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+// TestKeysTTLScrape checks the ttl histogram of keys sampled with RANDOMKEY,
+// the keys without ttl counted apart and the expired ones skipped.
+func TestKeysTTLScrape(t *testing.T) {
+
+	// The ttl of each key in milliseconds, -1 without ttl and -2 once expired.
+	ttls := map[string]int64{
+		"session:1": 30000,
+		"session:2": 60000,
+		"cache:1":   600000,
+		"config":    -1,
+		"gone":      -2,
+		"archive":   30 * 86400000,
+	}
+	keys := []string{"session:1", "session:2", "cache:1", "config", "gone", "archive"}
+
+	var mu sync.Mutex
+	next := 0
+	s := redistest.NewServer(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "INFO":
+			return redistest.Info("# Keyspace", "db0:keys=6,expires=4,avg_ttl=0")
+		case "RANDOMKEY":
+			mu.Lock()
+			defer mu.Unlock()
+			key := keys[next%len(keys)]
+			next++
+			return redistest.Bulk(key)
+		case "PTTL":
+			return redistest.Int(ttls[args[1]])
+		}
+		return ""
+	})
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr})
+	defer rdb.Close()
+
+	ch := make(chan prometheus.Metric, 10)
+	sc := &ScrapeContext{Clients: []*redis.Client{rdb}, KeyChecks: &KeyCheckSettings{SampleSize: len(keys)}}
+	if err := NewKeysTTLScraper(KeysTTLSettings{Buckets: []float64{60, 3600}}).Scrape(context.Background(), sc, ch, log.NewNopLogger()); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	var histogram *dto.Histogram
+	var noTTL float64
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+		switch m.Desc() {
+		case keyTTLSeconds:
+			histogram = pb.GetHistogram()
+		case keyNoTTLKeys:
+			noTTL = pb.GetGauge().GetValue()
+		}
+	}
+	if histogram == nil {
+		t.Fatal("no ttl histogram")
+	}
+
+	if got := histogram.GetSampleCount(); got != 4 {
+		t.Errorf("count = %d, want 4", got)
+	}
+	if got, want := histogram.GetSampleSum(), 30+60+600+30*86400.0; got != want {
+		t.Errorf("sum = %v, want %v", got, want)
+	}
+	got := map[float64]uint64{}
+	for _, b := range histogram.GetBucket() {
+		got[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+	// A ttl equal to a bound falls into its bucket.
+	if want := map[float64]uint64{60: 2, 3600: 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buckets = %v, want %v", got, want)
+	}
+	if noTTL != 1 {
+		t.Errorf("keys without ttl = %v, want 1", noTTL)
+	}
+}
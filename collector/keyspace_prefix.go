@@ -0,0 +1,312 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	redis "github.com/redis/go-redis/v9"
+)
+
+const (
+	keyspacePrefixNone  = "none"
+	keyspacePrefixOther = "other"
+)
+
+// KeyspacePrefixSettings configures the keyspace.prefix scraper, zero values
+// use the defaults.
+type KeyspacePrefixSettings struct {
+	// Delimiter splits key names into namespaces. Defaults to ":".
+	Delimiter string
+	// Depth is the number of leading delimited parts of a key name used as its
+	// prefix. Defaults to 2.
+	Depth int
+	// Regex extracts the prefix from a key name, the first capture group is
+	// used if any. It overrides the delimiter and depth.
+	Regex string
+	// SampleSize is the number of keys sampled per db on each scrape. Defaults
+	// to 1000.
+	SampleSize int
+	// SampleMethod is how keys are sampled, either randomkey or scan. Defaults
+	// to randomkey.
+	SampleMethod string
+	// MemorySamples is the SAMPLES argument of MEMORY USAGE for nested values.
+	// Defaults to 5.
+	MemorySamples int
+	// MaxPrefixes is the maximum number of prefixes exported per db, the rest
+	// are folded into the 'other' prefix. Defaults to 100.
+	MaxPrefixes int
+}
+
+var (
+	keyspacePrefixKeys = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "keyspace_prefix", "keys"),
+		"Estimated number of keys with the prefix, scaled from the sampled keys.",
+		[]string{"addr", "db", "prefix"},
+		nil,
+	)
+	keyspacePrefixMemory = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "keyspace_prefix", "memory_bytes"),
+		"Estimated memory usage of the keys with the prefix, scaled from the sampled keys.",
+		[]string{"addr", "db", "prefix"},
+		nil,
+	)
+	keyspacePrefixTTLRatio = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "keyspace_prefix", "ttl_ratio"),
+		"Share of the sampled keys with the prefix that have a ttl.",
+		[]string{"addr", "db", "prefix"},
+		nil,
+	)
+	keyspacePrefixSampled = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "keyspace_prefix", "sampled_keys"),
+		"Number of keys sampled to estimate the prefix attribution.",
+		[]string{"addr", "db"},
+		nil,
+	)
+)
+
+type keyspacePrefixStats struct {
+	sampled float64
+	memory  float64
+	withTTL float64
+}
+
+func (s *keyspacePrefixStats) merge(o *keyspacePrefixStats) {
+	s.sampled += o.sampled
+	s.memory += o.memory
+	s.withTTL += o.withTTL
+}
+
+type keyspacePrefixScraper struct {
+	settings KeyspacePrefixSettings
+	cursors  *scanCursors
+}
+
+func NewKeyspacePrefixScraper(settings KeyspacePrefixSettings) *keyspacePrefixScraper {
+	settings.Delimiter = stringSetting(settings.Delimiter, ":")
+	settings.Depth = intSetting(settings.Depth, 2)
+	settings.SampleSize = intSetting(settings.SampleSize, 1000)
+	settings.SampleMethod = stringSetting(settings.SampleMethod, sampleMethodRandomKey)
+	settings.MemorySamples = intSetting(settings.MemorySamples, 5)
+	settings.MaxPrefixes = intSetting(settings.MaxPrefixes, 100)
+
+	return &keyspacePrefixScraper{
+		settings: settings,
+		cursors:  newScanCursors(),
+	}
+}
+
+// keyPrefixFunc returns the function grouping keys by prefix from the settings.
+func (scraper *keyspacePrefixScraper) keyPrefixFunc(settings *KeyCheckSettings) (func(key string) string, error) {
+	if prefixRegex := stringSetting(settings.PrefixRegex, scraper.settings.Regex); prefixRegex != "" {
+		re, err := regexp.Compile(prefixRegex)
+		if err != nil {
+			return nil, err
+		}
+		return func(key string) string {
+			m := re.FindStringSubmatch(key)
+			switch {
+			case m == nil:
+				return keyspacePrefixNone
+			case len(m) > 1:
+				return m[1]
+			default:
+				return m[0]
+			}
+		}, nil
+	}
+
+	delimiter := stringSetting(settings.PrefixDelimiter, scraper.settings.Delimiter)
+	depth := intSetting(settings.PrefixDepth, scraper.settings.Depth)
+	return func(key string) string {
+		parts := strings.Split(key, delimiter)
+		// The last part is the id of the key, never a namespace.
+		n := len(parts) - 1
+		if depth < n {
+			n = depth
+		}
+		if n <= 0 {
+			return keyspacePrefixNone
+		}
+		return strings.Join(parts[:n], delimiter)
+	}, nil
+}
+
+func foldKeyspacePrefixes(prefixes map[string]*keyspacePrefixStats, maxPrefixes int) {
+	if maxPrefixes <= 0 || len(prefixes) <= maxPrefixes {
+		return
+	}
+
+	names := make([]string, 0, len(prefixes))
+	for name := range prefixes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		mi, mj := prefixes[names[i]].memory, prefixes[names[j]].memory
+		if mi != mj {
+			return mi > mj
+		}
+		return names[i] < names[j]
+	})
+
+	other := &keyspacePrefixStats{}
+	for _, name := range names[maxPrefixes-1:] {
+		other.merge(prefixes[name])
+		delete(prefixes, name)
+	}
+	if stats, ok := prefixes[keyspacePrefixOther]; ok {
+		stats.merge(other)
+	} else {
+		prefixes[keyspacePrefixOther] = other
+	}
+}
+
+func (scraper *keyspacePrefixScraper) scrapeDB(ctx context.Context, rdb *redis.Client, keys []string, prefixOf func(string) string) (map[string]*keyspacePrefixStats, error) {
+	pipe := rdb.Pipeline()
+	memCmds := make([]*redis.IntCmd, len(keys))
+	ttlCmds := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		memCmds[i] = pipe.MemoryUsage(ctx, key, scraper.settings.MemorySamples)
+		ttlCmds[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	prefixes := make(map[string]*keyspacePrefixStats)
+	for i, key := range keys {
+		mem, err := memCmds[i].Result()
+		if err != nil {
+			// The key expired after being sampled.
+			continue
+		}
+
+		prefix := prefixOf(key)
+		stats, ok := prefixes[prefix]
+		if !ok {
+			stats = &keyspacePrefixStats{}
+			prefixes[prefix] = stats
+		}
+		stats.sampled++
+		stats.memory += float64(mem)
+		if ttl := ttlCmds[i].Val(); ttl > 0 {
+			stats.withTTL++
+		}
+	}
+
+	return prefixes, nil
+}
+
+// Scrape implements Scraper.
+func (scraper *keyspacePrefixScraper) Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error {
+	settings := sc.keyChecks()
+	prefixOf, err := scraper.keyPrefixFunc(settings)
+	if err != nil {
+		return err
+	}
+
+	for _, rdb := range sc.Clients {
+		addr := rdb.Options().Addr
+
+		var dbs map[int]int64
+		dbs, err = GetRedisKeyspaceDBs(ctx, rdb)
+		if err != nil {
+			return err
+		}
+
+		for db, dbKeys := range dbs {
+			dbRdb := newDBClient(rdb, db)
+
+			var keys []string
+			var prefixes map[string]*keyspacePrefixStats
+			keys, err = scraper.cursors.sampleKeys(ctx, dbRdb,
+				stringSetting(settings.SampleMethod, scraper.settings.SampleMethod),
+				intSetting(settings.SampleSize, scraper.settings.SampleSize),
+			)
+			if err == nil {
+				prefixes, err = scraper.scrapeDB(ctx, dbRdb, keys, prefixOf)
+			}
+			dbRdb.Close()
+			if err != nil {
+				return err
+			}
+
+			var sampled float64
+			for _, stats := range prefixes {
+				sampled += stats.sampled
+			}
+			dbLabel := strconv.Itoa(db)
+			ch <- prometheus.MustNewConstMetric(keyspacePrefixSampled, prometheus.GaugeValue, sampled, addr, dbLabel)
+			if sampled == 0 {
+				continue
+			}
+
+			foldKeyspacePrefixes(prefixes, intSetting(settings.MaxPrefixes, scraper.settings.MaxPrefixes))
+
+			// Scale the sample up to the number of keys of the db.
+			scale := float64(dbKeys) / sampled
+			for prefix, stats := range prefixes {
+				ch <- prometheus.MustNewConstMetric(keyspacePrefixKeys, prometheus.GaugeValue, stats.sampled*scale, addr, dbLabel, prefix)
+				ch <- prometheus.MustNewConstMetric(keyspacePrefixMemory, prometheus.GaugeValue, stats.memory*scale, addr, dbLabel, prefix)
+				ch <- prometheus.MustNewConstMetric(keyspacePrefixTTLRatio, prometheus.GaugeValue, stats.withTTL/stats.sampled, addr, dbLabel, prefix)
+			}
+		}
+	}
+
+	return err
+}
+
+// Forget implements NodeStateScraper.
+func (scraper *keyspacePrefixScraper) Forget(addr string) {
+	scraper.cursors.forget(addr)
+}
+
+// Help implements Scraper.
+func (*keyspacePrefixScraper) Help() string {
+	return "Collect estimated key count and memory usage per key prefix from sampled keys of each redis node."
+}
+
+// Name implements Scraper.
+func (*keyspacePrefixScraper) Name() string {
+	return "keyspace.prefix"
+}
+
+// Version implements Scraper.
+func (*keyspacePrefixScraper) Version() string {
+	return "4.0"
+}
+
+// Commands implements CommandsScraper.
+func (*keyspacePrefixScraper) Commands() [][]interface{} {
+	return [][]interface{}{
+		{"info", "keyspace"},
+		{"select", "1"},
+		{"randomkey"},
+		{"scan", "0"},
+		{"memory", "usage", aclCheckKey},
+		{"pttl", aclCheckKey},
+	}
+}
+
+var _ CommandsScraper = &keyspacePrefixScraper{}
+var _ NodeStateScraper = &keyspacePrefixScraper{}
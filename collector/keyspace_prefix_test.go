@@ -0,0 +1,206 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
+	"github.com/xieyanke/redis_exporter/internal/redistest"
+)
+
+func TestKeyPrefixFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings *KeyCheckSettings
+		keys     map[string]string
+	}{
+		{
+			name:     "delimiter",
+			settings: &KeyCheckSettings{PrefixDelimiter: ":", PrefixDepth: 2},
+			keys: map[string]string{
+				"app:user:session:1": "app:user",
+				"user:123":           "user",
+				"plain":              "none",
+			},
+		},
+		{
+			name:     "depth 1",
+			settings: &KeyCheckSettings{PrefixDelimiter: "/", PrefixDepth: 1},
+			keys: map[string]string{
+				"cache/page/1": "cache",
+				"cache:page:1": "none",
+			},
+		},
+		{
+			name:     "regex group",
+			settings: &KeyCheckSettings{PrefixDelimiter: ":", PrefixDepth: 2, PrefixRegex: `^tenant-\d+:(\w+):`},
+			keys: map[string]string{
+				"tenant-1:orders:42": "orders",
+				"tenant-2:carts:7":   "carts",
+				"orders:42":          "none",
+			},
+		},
+		{
+			name:     "regex match",
+			settings: &KeyCheckSettings{PrefixRegex: `^[a-z]+`},
+			keys: map[string]string{
+				"orders:42": "orders",
+				"42":        "none",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefixOf, err := NewKeyspacePrefixScraper(KeyspacePrefixSettings{}).keyPrefixFunc(tt.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.keys {
+				if got := prefixOf(key); got != want {
+					t.Errorf("prefix of %q = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+
+	if _, err := NewKeyspacePrefixScraper(KeyspacePrefixSettings{}).keyPrefixFunc(&KeyCheckSettings{PrefixRegex: "("}); err == nil {
+		t.Error("invalid regex accepted")
+	}
+}
+
+func TestFoldKeyspacePrefixes(t *testing.T) {
+	prefixes := func() map[string]*keyspacePrefixStats {
+		return map[string]*keyspacePrefixStats{
+			"a":     {sampled: 1, memory: 500, withTTL: 1},
+			"b":     {sampled: 2, memory: 300},
+			"c":     {sampled: 3, memory: 300, withTTL: 2},
+			"d":     {sampled: 4, memory: 100, withTTL: 4},
+			"other": {sampled: 5, memory: 50},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		maxPrefixes int
+		want        map[string]*keyspacePrefixStats
+	}{
+		{name: "unlimited", want: prefixes()},
+		{name: "under the limit", maxPrefixes: 5, want: prefixes()},
+		{
+			// The existing other prefix takes the folded ones, ties are broken
+			// by name.
+			name:        "folded",
+			maxPrefixes: 3,
+			want: map[string]*keyspacePrefixStats{
+				"a":     {sampled: 1, memory: 500, withTTL: 1},
+				"b":     {sampled: 2, memory: 300},
+				"other": {sampled: 12, memory: 450, withTTL: 6},
+			},
+		},
+		{
+			name:        "one",
+			maxPrefixes: 1,
+			want: map[string]*keyspacePrefixStats{
+				"other": {sampled: 15, memory: 1250, withTTL: 7},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := prefixes()
+			foldKeyspacePrefixes(got, tt.maxPrefixes)
+			if !reflect.DeepEqual(got, tt.want) {
+				for name, stats := range got {
+					t.Errorf("got %s: %+v", name, *stats)
+				}
+			}
+		})
+	}
+}
+
+// TestKeyspacePrefixScrapeScan checks that the keys are sampled with SCAN when
+// it is the sample method, resuming from the cursor of the previous scrape.
+func TestKeyspacePrefixScrapeScan(t *testing.T) {
+	pages := map[string]string{
+		"0": redistest.Array(redistest.Bulk("5"), redistest.Array(redistest.Bulk("user:1"), redistest.Bulk("user:2"))),
+		"5": redistest.Array(redistest.Bulk("0"), redistest.Array(redistest.Bulk("order:1"), redistest.Bulk("order:2"))),
+	}
+	var mu sync.Mutex
+	var cursors []string
+	s := redistest.NewServer(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "INFO":
+			return redistest.Info("# Keyspace", "db0:keys=4,expires=0,avg_ttl=0")
+		case "SCAN":
+			mu.Lock()
+			cursors = append(cursors, args[1])
+			mu.Unlock()
+			return pages[args[1]]
+		case "MEMORY":
+			return redistest.Int(100)
+		case "PTTL":
+			return redistest.Int(-1)
+		}
+		// RANDOMKEY isn't answered.
+		return ""
+	})
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr})
+	defer rdb.Close()
+
+	scraper := NewKeyspacePrefixScraper(KeyspacePrefixSettings{Depth: 1, SampleSize: 2})
+	sc := &ScrapeContext{Clients: []*redis.Client{rdb}, KeyChecks: &KeyCheckSettings{SampleMethod: sampleMethodScan}}
+	for _, want := range []string{"user", "order"} {
+		ch := make(chan prometheus.Metric, 10)
+		if err := scraper.Scrape(context.Background(), sc, ch, log.NewNopLogger()); err != nil {
+			t.Fatal(err)
+		}
+		close(ch)
+
+		var prefixes []string
+		for m := range ch {
+			if m.Desc() != keyspacePrefixKeys {
+				continue
+			}
+			pb := &dto.Metric{}
+			if err := m.Write(pb); err != nil {
+				t.Fatal(err)
+			}
+			for _, l := range pb.GetLabel() {
+				if l.GetName() == "prefix" {
+					prefixes = append(prefixes, l.GetValue())
+				}
+			}
+		}
+		if !reflect.DeepEqual(prefixes, []string{want}) {
+			t.Errorf("prefixes = %v, want [%s]", prefixes, want)
+		}
+	}
+
+	if want := []string{"0", "5"}; !reflect.DeepEqual(cursors, want) {
+		t.Errorf("SCAN cursors = %v, want %v", cursors, want)
+	}
+}
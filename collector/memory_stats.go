@@ -0,0 +1,139 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type memoryStatsScraper struct{}
+
+func NewMemoryStatsScraper() *memoryStatsScraper {
+	return &memoryStatsScraper{}
+}
+
+// memoryStats is a MEMORY STATS reply split into node wide values and
+// per db values keyed by db number.
+type memoryStats struct {
+	node map[string]float64
+	dbs  map[string]map[string]float64
+}
+
+// parseMemoryStatsReply parses a MEMORY STATS reply. Fields that are not
+// numeric are skipped, `db.N` fields are nested maps.
+func parseMemoryStatsReply(reply interface{}) *memoryStats {
+	stats := &memoryStats{
+		node: make(map[string]float64),
+		dbs:  make(map[string]map[string]float64),
+	}
+
+	for k, v := range parseRedisMapReply(reply) {
+		if strings.HasPrefix(k, "db.") {
+			db := strings.TrimPrefix(k, "db.")
+			if _, err := strconv.Atoi(db); err == nil {
+				dbStats := make(map[string]float64)
+				for dk, dv := range parseRedisMapReply(v) {
+					if f64, ok := redisReplyToFloat64(dv); ok {
+						dbStats[dk] = f64
+					}
+				}
+				stats.dbs[db] = dbStats
+				continue
+			}
+		}
+
+		if f64, ok := redisReplyToFloat64(v); ok {
+			stats.node[k] = f64
+		}
+	}
+
+	return stats
+}
+
+func memoryStatsMetricName(field string) string {
+	return strings.NewReplacer(".", "_", "-", "_").Replace(field)
+}
+
+// Scrape implements Scraper.
+func (scraper *memoryStatsScraper) Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error {
+	var err error
+
+	for _, rdb := range sc.Clients {
+		addr := rdb.Options().Addr
+
+		var res interface{}
+		res, err = rdb.Do(ctx, "MEMORY", "STATS").Result()
+		if err != nil {
+			return err
+		}
+
+		stats := parseMemoryStatsReply(res)
+		for k, v := range stats.node {
+			desc := prometheus.NewDesc(
+				prometheus.BuildFQName(Namespace, "memory_stats", memoryStatsMetricName(k)),
+				fmt.Sprintf("The %s field of redis memory stats.", k),
+				[]string{"addr"},
+				nil,
+			)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, addr)
+		}
+
+		for db, dbStats := range stats.dbs {
+			for k, v := range dbStats {
+				desc := prometheus.NewDesc(
+					prometheus.BuildFQName(Namespace, "memory_stats", "db_"+memoryStatsMetricName(k)),
+					fmt.Sprintf("The %s field of redis memory stats per db.", k),
+					[]string{"addr", "db"},
+					nil,
+				)
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, addr, db)
+			}
+		}
+	}
+
+	return err
+}
+
+// Help implements Scraper.
+func (*memoryStatsScraper) Help() string {
+	return "Collect memory stats from each redis node."
+}
+
+// Name implements Scraper.
+func (*memoryStatsScraper) Name() string {
+	return "memory.stats"
+}
+
+// Version implements Scraper.
+func (*memoryStatsScraper) Version() string {
+	return "4.0"
+}
+
+// Commands implements CommandsScraper.
+func (*memoryStatsScraper) Commands() [][]interface{} {
+	return [][]interface{}{
+		{"memory", "stats"},
+	}
+}
+
+var _ CommandsScraper = &memoryStatsScraper{}
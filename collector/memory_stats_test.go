@@ -0,0 +1,122 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/xieyanke/redis_exporter/internal/redistest"
+)
+
+func TestParseMemoryStatsReply(t *testing.T) {
+	want := &memoryStats{
+		node: map[string]float64{
+			"peak.allocated":                1048576,
+			"keys.count":                    5,
+			"dataset.percentage":            83.25,
+			"allocator.fragmentation.ratio": 1.5,
+		},
+		dbs: map[string]map[string]float64{
+			"0": {"overhead.hashtable.main": 72, "overhead.hashtable.expires": 0},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		reply interface{}
+	}{
+		{
+			// Doubles are bulk strings and maps are flat arrays in RESP2.
+			name: "resp2",
+			reply: []interface{}{
+				"peak.allocated", int64(1048576),
+				"db.0", []interface{}{"overhead.hashtable.main", int64(72), "overhead.hashtable.expires", int64(0)},
+				"keys.count", int64(5),
+				"dataset.percentage", "83.25",
+				"allocator.fragmentation.ratio", "1.5",
+				"allocator.name", "jemalloc-5.3.0",
+			},
+		},
+		{
+			name: "resp3",
+			reply: map[interface{}]interface{}{
+				"peak.allocated": int64(1048576),
+				"db.0": map[interface{}]interface{}{
+					"overhead.hashtable.main":    int64(72),
+					"overhead.hashtable.expires": int64(0),
+				},
+				"keys.count":                    int64(5),
+				"dataset.percentage":            83.25,
+				"allocator.fragmentation.ratio": 1.5,
+				"allocator.name":                "jemalloc-5.3.0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseMemoryStatsReply(tt.reply); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// TestParseMemoryStatsReplyClient checks the parsing of the reply as returned
+// by the client for a RESP2 server.
+func TestParseMemoryStatsReplyClient(t *testing.T) {
+	s := redistest.NewServer(t, func(args []string) string {
+		if strings.EqualFold(args[0], "MEMORY") {
+			return redistest.Array(
+				redistest.Bulk("peak.allocated"), redistest.Int(1048576),
+				redistest.Bulk("db.0"), redistest.Array(redistest.Bulk("overhead.hashtable.main"), redistest.Int(72)),
+				redistest.Bulk("dataset.percentage"), redistest.Bulk("83.25"),
+			)
+		}
+		return ""
+	})
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr})
+	defer rdb.Close()
+
+	reply, err := rdb.Do(context.Background(), "MEMORY", "STATS").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &memoryStats{
+		node: map[string]float64{"peak.allocated": 1048576, "dataset.percentage": 83.25},
+		dbs:  map[string]map[string]float64{"0": {"overhead.hashtable.main": 72}},
+	}
+	if got := parseMemoryStatsReply(reply); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestMemoryStatsMetricName(t *testing.T) {
+	for field, want := range map[string]string{
+		"peak.allocated":                "peak_allocated",
+		"overhead.hashtable.main":       "overhead_hashtable_main",
+		"allocator-fragmentation.ratio": "allocator_fragmentation_ratio",
+	} {
+		if got := memoryStatsMetricName(field); got != want {
+			t.Errorf("memoryStatsMetricName(%q) = %q, want %q", field, got, want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pubSubNumSubBatch is the number of channels passed to a single NUMSUB call.
+const pubSubNumSubBatch = 100
+
+// PubSubSettings configures the pubsub scraper, zero values use the defaults.
+type PubSubSettings struct {
+	// Pattern of the channels listed with PUBSUB CHANNELS and PUBSUB
+	// SHARDCHANNELS. Defaults to "*".
+	Pattern string
+	// Channels are always exported, even without subscribers.
+	Channels []string
+	// MaxChannels is the maximum number of listed channels exported per node,
+	// the ones with most subscribers are kept. Defaults to 100.
+	MaxChannels int
+}
+
+var (
+	pubSubChannelSubscribers = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "pubsub", "channel_subscribers"),
+		"Number of subscribers of the channel.",
+		[]string{"addr", "channel"},
+		nil,
+	)
+	pubSubShardChannelSubscribers = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "pubsub", "shard_channel_subscribers"),
+		"Number of subscribers of the shard channel.",
+		[]string{"addr", "channel"},
+		nil,
+	)
+	pubSubActiveChannels = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "pubsub", "channels"),
+		"Number of active channels matching the pattern.",
+		[]string{"addr"},
+		nil,
+	)
+	pubSubActiveShardChannels = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "pubsub", "shard_channels"),
+		"Number of active shard channels matching the pattern.",
+		[]string{"addr"},
+		nil,
+	)
+)
+
+type pubSubScraper struct {
+	settings PubSubSettings
+}
+
+func NewPubSubScraper(settings PubSubSettings) *pubSubScraper {
+	settings.Pattern = stringSetting(settings.Pattern, "*")
+	settings.MaxChannels = intSetting(settings.MaxChannels, 100)
+	return &pubSubScraper{settings: settings}
+}
+
+// pubSubSubscribers returns the subscriber count of the listed channels capped to
+// maxChannels, plus the fixed channels which are always kept.
+func pubSubSubscribers(listed, fixed []string, maxChannels int, numSub func(channels ...string) (map[string]int64, error)) (map[string]int64, error) {
+	seen := make(map[string]bool, len(listed)+len(fixed))
+	var channels []string
+	for _, channel := range append(append([]string{}, fixed...), listed...) {
+		if !seen[channel] {
+			seen[channel] = true
+			channels = append(channels, channel)
+		}
+	}
+
+	subs := make(map[string]int64, len(channels))
+	for i := 0; i < len(channels); i += pubSubNumSubBatch {
+		end := i + pubSubNumSubBatch
+		if end > len(channels) {
+			end = len(channels)
+		}
+		m, err := numSub(channels[i:end]...)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range m {
+			subs[k] = v
+		}
+	}
+
+	if maxChannels <= 0 || len(listed) <= maxChannels {
+		return subs, nil
+	}
+
+	isFixed := make(map[string]bool, len(fixed))
+	for _, channel := range fixed {
+		isFixed[channel] = true
+	}
+	var capped []string
+	for _, channel := range listed {
+		if !isFixed[channel] {
+			capped = append(capped, channel)
+		}
+	}
+	sort.Slice(capped, func(i, j int) bool {
+		if subs[capped[i]] != subs[capped[j]] {
+			return subs[capped[i]] > subs[capped[j]]
+		}
+		return capped[i] < capped[j]
+	})
+	if len(capped) > maxChannels {
+		for _, channel := range capped[maxChannels:] {
+			delete(subs, channel)
+		}
+	}
+
+	return subs, nil
+}
+
+// Scrape implements Scraper.
+func (scraper *pubSubScraper) Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error {
+	var err error
+
+	fixed := scraper.settings.Channels
+
+	for _, rdb := range sc.Clients {
+		addr := rdb.Options().Addr
+
+		var listed []string
+		listed, err = rdb.PubSubChannels(ctx, scraper.settings.Pattern).Result()
+		if err != nil {
+			return err
+		}
+
+		var subs map[string]int64
+		subs, err = pubSubSubscribers(listed, fixed, scraper.settings.MaxChannels, func(channels ...string) (map[string]int64, error) {
+			return rdb.PubSubNumSub(ctx, channels...).Result()
+		})
+		if err != nil {
+			return err
+		}
+
+		ch <- prometheus.MustNewConstMetric(pubSubActiveChannels, prometheus.GaugeValue, float64(len(listed)), addr)
+		for channel, n := range subs {
+			ch <- prometheus.MustNewConstMetric(pubSubChannelSubscribers, prometheus.GaugeValue, float64(n), addr, channel)
+		}
+
+		var version float64
+		version, err = GetRedisMajorVersion(ctx, rdb, logger)
+		if err != nil {
+			return err
+		}
+		if version < 7.0 {
+			continue
+		}
+
+		listed, err = rdb.PubSubShardChannels(ctx, scraper.settings.Pattern).Result()
+		if err != nil {
+			return err
+		}
+
+		subs, err = pubSubSubscribers(listed, fixed, scraper.settings.MaxChannels, func(channels ...string) (map[string]int64, error) {
+			return rdb.PubSubShardNumSub(ctx, channels...).Result()
+		})
+		if err != nil {
+			return err
+		}
+
+		ch <- prometheus.MustNewConstMetric(pubSubActiveShardChannels, prometheus.GaugeValue, float64(len(listed)), addr)
+		for channel, n := range subs {
+			ch <- prometheus.MustNewConstMetric(pubSubShardChannelSubscribers, prometheus.GaugeValue, float64(n), addr, channel)
+		}
+	}
+
+	return err
+}
+
+// Help implements Scraper.
+func (*pubSubScraper) Help() string {
+	return "Collect subscribers per pub/sub channel and shard channel from each redis node."
+}
+
+// Name implements Scraper.
+func (*pubSubScraper) Name() string {
+	return "pubsub"
+}
+
+// Version implements Scraper.
+func (*pubSubScraper) Version() string {
+	return "2.8"
+}
+
+// Commands implements CommandsScraper.
+func (*pubSubScraper) Commands() [][]interface{} {
+	return [][]interface{}{
+		{"pubsub", "channels"},
+		{"pubsub", "numsub"},
+		{"pubsub", "shardchannels"},
+		{"pubsub", "shardnumsub"},
+		{"info", "server"},
+	}
+}
+
+var _ CommandsScraper = &pubSubScraper{}
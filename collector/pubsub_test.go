@@ -0,0 +1,116 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPubSubSubscribers(t *testing.T) {
+	subscribers := map[string]int64{"news": 5, "alerts": 9, "chat": 5, "audit": 1, "jobs": 0}
+
+	tests := []struct {
+		name        string
+		listed      []string
+		fixed       []string
+		maxChannels int
+		want        map[string]int64
+	}{
+		{
+			name:   "uncapped",
+			listed: []string{"news", "alerts", "chat"},
+			want:   map[string]int64{"news": 5, "alerts": 9, "chat": 5},
+		},
+		{
+			// The fixed channels are exported even without subscribers.
+			name:        "fixed",
+			listed:      []string{"news"},
+			fixed:       []string{"jobs", "news"},
+			maxChannels: 1,
+			want:        map[string]int64{"news": 5, "jobs": 0},
+		},
+		{
+			// The listed channels with most subscribers are kept, ties are
+			// broken by name.
+			name:        "capped",
+			listed:      []string{"news", "alerts", "chat", "audit"},
+			maxChannels: 2,
+			want:        map[string]int64{"alerts": 9, "chat": 5},
+		},
+		{
+			name:        "capped with fixed",
+			listed:      []string{"news", "alerts", "chat", "audit"},
+			fixed:       []string{"audit"},
+			maxChannels: 1,
+			want:        map[string]int64{"alerts": 9, "audit": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pubSubSubscribers(tt.listed, tt.fixed, tt.maxChannels, func(channels ...string) (map[string]int64, error) {
+				m := make(map[string]int64, len(channels))
+				for _, channel := range channels {
+					m[channel] = subscribers[channel]
+				}
+				return m, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPubSubSubscribersBatches(t *testing.T) {
+	var listed []string
+	for i := 0; i < 2*pubSubNumSubBatch+1; i++ {
+		listed = append(listed, fmt.Sprintf("channel-%03d", i))
+	}
+
+	var batches []int
+	got, err := pubSubSubscribers(listed, nil, 0, func(channels ...string) (map[string]int64, error) {
+		batches = append(batches, len(channels))
+		m := make(map[string]int64, len(channels))
+		for _, channel := range channels {
+			m[channel] = 1
+		}
+		return m, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{pubSubNumSubBatch, pubSubNumSubBatch, 1}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+	if len(got) != len(listed) {
+		t.Errorf("got %d channels, want %d", len(got), len(listed))
+	}
+
+	_, err = pubSubSubscribers(listed, nil, 0, func(channels ...string) (map[string]int64, error) {
+		return nil, errors.New("NOPERM")
+	})
+	if err == nil {
+		t.Error("NUMSUB error ignored")
+	}
+}
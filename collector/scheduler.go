@@ -0,0 +1,137 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"sync"
+)
+
+// Scheduler bounds the number of (node, scraper) scrapes running at once, over
+// all the exporters sharing it. A limit of 0 means no limit.
+type Scheduler struct {
+	global          chan struct{}
+	nodeConcurrency int
+
+	mu    sync.Mutex
+	nodes map[string]*nodeSlots
+}
+
+// nodeSlots are the slots of a node, users counts the scrapes holding or
+// waiting for one of them.
+type nodeSlots struct {
+	sem       chan struct{}
+	users     int
+	forgotten bool
+}
+
+// NewScheduler returns a scheduler running at most concurrency scrapes at
+// once, and at most nodeConcurrency on a single node.
+func NewScheduler(concurrency, nodeConcurrency int) *Scheduler {
+	s := &Scheduler{
+		nodeConcurrency: nodeConcurrency,
+		nodes:           make(map[string]*nodeSlots),
+	}
+	if concurrency > 0 {
+		s.global = make(chan struct{}, concurrency)
+	}
+	return s
+}
+
+// nodeSlots returns the slots of addr, which are held until leave is called.
+func (s *Scheduler) nodeSlots(addr string) *nodeSlots {
+	if s.nodeConcurrency <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slots, ok := s.nodes[addr]
+	if !ok {
+		slots = &nodeSlots{sem: make(chan struct{}, s.nodeConcurrency)}
+		s.nodes[addr] = slots
+	}
+	slots.users++
+	return slots
+}
+
+// leave releases the slots of addr, which are dropped with their last user if
+// the node was forgotten meanwhile.
+func (s *Scheduler) leave(addr string, slots *nodeSlots) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slots.users--
+	if slots.forgotten && slots.users == 0 && s.nodes[addr] == slots {
+		delete(s.nodes, addr)
+	}
+}
+
+// acquire waits for a slot to scrape addr, it returns the function releasing
+// the slot or the error of ctx if it is done first.
+func (s *Scheduler) acquire(ctx context.Context, addr string) (func(), error) {
+	node := s.nodeSlots(addr)
+
+	if node != nil {
+		select {
+		case node.sem <- struct{}{}:
+		case <-ctx.Done():
+			s.leave(addr, node)
+			return nil, ctx.Err()
+		}
+	}
+	if s.global != nil {
+		select {
+		case s.global <- struct{}{}:
+		case <-ctx.Done():
+			if node != nil {
+				<-node.sem
+				s.leave(addr, node)
+			}
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		if s.global != nil {
+			<-s.global
+		}
+		if node != nil {
+			<-node.sem
+			s.leave(addr, node)
+		}
+	}, nil
+}
+
+// Forget drops the slots of a node which isn't scraped anymore. The slots of a
+// node still in use are dropped once the last scrape using them releases its
+// slot, so the node limit holds meanwhile.
+func (s *Scheduler) Forget(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slots, ok := s.nodes[addr]
+	if !ok {
+		return
+	}
+	if slots.users == 0 {
+		delete(s.nodes, addr)
+		return
+	}
+	slots.forgotten = true
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func (s *Scheduler) nodeCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.nodes)
+}
+
+// TestSchedulerForget checks that forgetting a node keeps its limit while a
+// scrape holds one of its slots, and drops the slots with the last one.
+func TestSchedulerForget(t *testing.T) {
+	const addr = "10.0.0.1:6379"
+	s := NewScheduler(0, 1)
+
+	release, err := s.acquire(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Forget(addr)
+	if n := s.nodeCount(); n != 1 {
+		t.Errorf("got %d nodes while a slot is held, want 1", n)
+	}
+
+	// The node limit still applies to the scrapes started after Forget.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(ctx, addr); err == nil {
+		t.Fatal("got a second slot of a node limited to 1")
+	}
+
+	release()
+	if n := s.nodeCount(); n != 0 {
+		t.Errorf("got %d nodes after the last release, want 0", n)
+	}
+
+	release, err = s.acquire(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	s.Forget(addr)
+	if n := s.nodeCount(); n != 0 {
+		t.Errorf("got %d nodes after forgetting an idle node, want 0", n)
+	}
+}
@@ -24,6 +24,25 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// ScrapeContext is the state of a single scrape, shared by the scrapers it runs.
+// Scrapers serve concurrent scrapes, so they keep per-scrape state in it or in
+// local variables, never in their own fields.
+type ScrapeContext struct {
+	// Clients are the redis nodes to scrape.
+	Clients []*redis.Client
+	// KeyChecks overrides the key sampling settings, nil keeps the settings of
+	// the scrapers.
+	KeyChecks *KeyCheckSettings
+}
+
+// keyChecks returns the key check settings of the scrape.
+func (sc *ScrapeContext) keyChecks() *KeyCheckSettings {
+	if sc.KeyChecks == nil {
+		return &KeyCheckSettings{}
+	}
+	return sc.KeyChecks
+}
+
 type Scraper interface {
 	// Name of the Scraper. Should be unique.
 	Name() string
@@ -32,5 +51,30 @@ type Scraper interface {
 	// Mininum version of Redis from which scraper is available.
 	Version() string
 	// Scrape collects data from redis node and sends it over channel as prometheus metric.
-	Scrape(ctx context.Context, rdbs []*redis.Client, ch chan<- prometheus.Metric, logger log.Logger) error
+	Scrape(ctx context.Context, sc *ScrapeContext, ch chan<- prometheus.Metric, logger log.Logger) error
+}
+
+// BackgroundScraper is a Scraper which collects data on its own schedule,
+// outside of the prometheus scrape path. Its Scrape only exports the latest results.
+type BackgroundScraper interface {
+	Scraper
+	// Start runs the background collection until ctx is done, nodes returns the
+	// redis nodes to collect from on each run.
+	Start(ctx context.Context, nodes func(context.Context) []*redis.Options, logger log.Logger)
+}
+
+// NodeStateScraper is a Scraper which keeps state for each redis node between
+// scrapes.
+type NodeStateScraper interface {
+	Scraper
+	// Forget drops the state of a node which isn't scraped anymore.
+	Forget(addr string)
+}
+
+// CommandsScraper is a Scraper which can tell the redis commands it runs, so the
+// ACL permissions of the exporter user can be checked before scraping.
+type CommandsScraper interface {
+	Scraper
+	// Commands returns example invocations of every command run by Scrape.
+	Commands() [][]interface{}
 }
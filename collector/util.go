@@ -2,7 +2,9 @@ package collector
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
@@ -33,24 +35,190 @@ func GetRedisMajorVersion(ctx context.Context, rdb redis.UniversalClient, logger
 	return versionNum, nil
 }
 
-func GetRedisClusterNodes(ctx context.Context, rdb *redis.Client) ([]string, error) {
+// RedisNode is a node found by the discovery of a cluster, a sentinel or the
+// replicas of a master.
+type RedisNode struct {
+	Addr string
+	// Role is master or slave, empty when unknown.
+	Role string
+	// Shard identifies the nodes replicating the same data: the slots of the
+	// master in a cluster, the master name for a sentinel, and the address of
+	// the top master for replicas.
+	Shard string
+	// MasterID identifies the master of a replica, or the master itself: the
+	// node ID in a cluster, the run ID for a sentinel, and the address of the
+	// master for replicas.
+	MasterID string
+}
+
+// GetRedisClusterNodes returns the nodes listed by CLUSTER NODES, except the
+// ones without an address or still in handshake.
+func GetRedisClusterNodes(ctx context.Context, rdb *redis.Client) ([]RedisNode, error) {
 	result, err := rdb.ClusterNodes(ctx).Result()
-	defer rdb.Close()
+	if err != nil {
+		return nil, err
+	}
+	return parseRedisClusterNodes(result), nil
+}
+
+// parseRedisClusterNodes parses the reply of CLUSTER NODES.
+func parseRedisClusterNodes(result string) []RedisNode {
+	// <id> <ip:port@cport[,hostname]> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+	type clusterNode struct {
+		RedisNode
+		id     string
+		master string
+		slots  []string
+	}
+	var nodes []*clusterNode
+	slots := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(result), "\n") {
+		tokens := strings.Fields(line)
+		if len(tokens) < 8 {
+			continue
+		}
+		flags := make(map[string]bool)
+		for _, flag := range strings.Split(tokens[2], ",") {
+			flags[flag] = true
+		}
+		if flags["noaddr"] || flags["handshake"] {
+			continue
+		}
+
+		node := &clusterNode{id: tokens[0], master: tokens[3]}
+		node.Addr = strings.Split(tokens[1], "@")[0]
+		switch {
+		case flags["master"]:
+			node.Role = "master"
+			node.master = node.id
+		case flags["slave"]:
+			node.Role = "slave"
+		}
+		for _, slot := range tokens[8:] {
+			// Slots being migrated are listed as [slot->-id] or [slot-<-id].
+			if !strings.HasPrefix(slot, "[") {
+				node.slots = append(node.slots, slot)
+			}
+		}
+		if node.Role == "master" {
+			slots[node.id] = node.slots
+		}
+		nodes = append(nodes, node)
+	}
 
+	addrs := make([]RedisNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node.master != "-" {
+			node.MasterID = node.master
+			node.Shard = strings.Join(slots[node.master], ",")
+		}
+		addrs = append(addrs, node.RedisNode)
+	}
+
+	return addrs
+}
+
+// GetRedisMode returns the redis_mode of a node, standalone, cluster or
+// sentinel, and its role, master or slave, or sentinel for sentinels.
+func GetRedisMode(ctx context.Context, rdb *redis.Client) (string, string, error) {
+	section, err := rdb.Info(ctx, "server").Result()
 	if err != nil {
-		return []string{}, err
+		return "", "", err
+	}
+	mode := parseRedisInfoResp(section)["redis_mode"]
+	if mode == "" {
+		mode = "standalone"
+	}
+	if mode == "sentinel" {
+		return mode, mode, nil
 	}
 
-	var addrs []string
-	result = strings.TrimSpace(result)
-	lines := strings.Split(result, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		tokens := strings.Split(line, " ")
-		addrs = append(addrs, strings.Split(tokens[1], "@")[0])
+	section, err = rdb.Info(ctx, "replication").Result()
+	if err != nil {
+		return "", "", err
 	}
+	return mode, parseRedisInfoResp(section)["role"], nil
+}
 
-	return addrs, nil
+// GetRedisSentinelNodes returns the masters monitored by a sentinel and their
+// replicas.
+func GetRedisSentinelNodes(ctx context.Context, rdb *redis.Client) ([]RedisNode, error) {
+	masters, err := rdb.Do(ctx, "SENTINEL", "MASTERS").Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []RedisNode
+	for _, reply := range masters {
+		master := parseRedisMapReply(reply)
+		name, runID := fmt.Sprint(master["name"]), fmt.Sprint(master["runid"])
+		nodes = append(nodes, RedisNode{
+			Addr:     net.JoinHostPort(fmt.Sprint(master["ip"]), fmt.Sprint(master["port"])),
+			Role:     "master",
+			Shard:    name,
+			MasterID: runID,
+		})
+
+		replicas, err := rdb.Do(ctx, "SENTINEL", "SLAVES", name).Slice()
+		if err != nil {
+			return nil, err
+		}
+		for _, reply := range replicas {
+			replica := parseRedisMapReply(reply)
+			nodes = append(nodes, RedisNode{
+				Addr:     net.JoinHostPort(fmt.Sprint(replica["ip"]), fmt.Sprint(replica["port"])),
+				Role:     "slave",
+				Shard:    name,
+				MasterID: runID,
+			})
+		}
+	}
+
+	return nodes, nil
+}
+
+// GetRedisReplicas returns the role of a node and the addresses of its
+// replicas, from the slaveN lines of INFO replication.
+func GetRedisReplicas(ctx context.Context, rdb *redis.Client) (string, []string, error) {
+	section, err := rdb.Info(ctx, "replication").Result()
+	if err != nil {
+		return "", nil, err
+	}
+
+	role, replicas := parseRedisReplicas(section)
+	return role, replicas, nil
+}
+
+// parseRedisReplicas returns the role and the replica addresses of the
+// `slaveN:ip=...,port=...` lines of an INFO replication section.
+func parseRedisReplicas(section string) (string, []string) {
+	var role string
+	var replicas []string
+	for _, line := range strings.Split(section, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if kv[0] == "role" {
+			role = kv[1]
+			continue
+		}
+		if !strings.HasPrefix(kv[0], "slave") || strings.HasPrefix(kv[0], "slave_") {
+			continue
+		}
+
+		fields := make(map[string]string)
+		for _, item := range strings.Split(kv[1], ",") {
+			if i := strings.Index(item, "="); i >= 0 {
+				fields[item[:i]] = item[i+1:]
+			}
+		}
+		if fields["ip"] != "" && fields["port"] != "" {
+			replicas = append(replicas, net.JoinHostPort(fields["ip"], fields["port"]))
+		}
+	}
+
+	return role, replicas
 }
 
 func parseRedisInfoResp(resp string) map[string]string {
@@ -101,3 +269,108 @@ func checkParseRedisInfoRespError(key, addr string, err error, logger log.Logger
 		level.Error(logger).Log("msg", fmt.Sprintf("parse %s value failed from %s", key, addr), "err", err)
 	}
 }
+
+// pipelineError returns the error of a pipeline execution unless it is the reply
+// of one of its commands, which only fails that command. Connection and context
+// errors fail the whole pipeline.
+func pipelineError(err error) error {
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) {
+		return nil
+	}
+	return err
+}
+
+// parseRedisMapReply flattens a map reply, which is an array of alternating keys
+// and values in RESP2 and a map in RESP3.
+func parseRedisMapReply(reply interface{}) map[string]interface{} {
+	m := make(map[string]interface{})
+	switch v := reply.(type) {
+	case []interface{}:
+		for i := 0; i+1 < len(v); i += 2 {
+			m[fmt.Sprint(v[i])] = v[i+1]
+		}
+	case map[interface{}]interface{}:
+		for k, val := range v {
+			m[fmt.Sprint(k)] = val
+		}
+	case map[string]interface{}:
+		for k, val := range v {
+			m[k] = val
+		}
+	}
+	return m
+}
+
+// redisReplyToFloat64 converts a numeric reply, doubles are bulk strings in RESP2.
+func redisReplyToFloat64(reply interface{}) (float64, bool) {
+	switch v := reply.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f64, err := strconv.ParseFloat(v, 64)
+		return f64, err == nil
+	}
+	return 0, false
+}
+
+// GetRedisKeyspaceDBs returns the number of keys of each non empty db from INFO keyspace.
+func GetRedisKeyspaceDBs(ctx context.Context, rdb *redis.Client) (map[int]int64, error) {
+	section, err := rdb.Info(ctx, "keyspace").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	dbs := make(map[int]int64)
+	for k, v := range parseRedisInfoKeyspaceOrCmdtatsResp(section) {
+		if !strings.HasPrefix(k, "db") || !strings.HasSuffix(k, "_keys") {
+			continue
+		}
+		db, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(k, "db"), "_keys"))
+		if err != nil {
+			continue
+		}
+		keys, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		dbs[db] = keys
+	}
+
+	return dbs, nil
+}
+
+// redisClusterKeySlot returns the cluster hash slot of a key, honouring hash tags.
+func redisClusterKeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % 16384)
+}
+
+// crc16 implements the CRC16-XMODEM checksum used by redis cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
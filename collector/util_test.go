@@ -0,0 +1,260 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/xieyanke/redis_exporter/internal/redistest"
+)
+
+func TestParseRedisReplicas(t *testing.T) {
+	tests := []struct {
+		name     string
+		section  string
+		role     string
+		replicas []string
+	}{
+		{
+			name: "master",
+			section: "# Replication\r\nrole:master\r\nconnected_slaves:2\r\n" +
+				"slave0:ip=10.0.0.2,port=6379,state=online,offset=100,lag=0\r\n" +
+				"slave1:ip=10.0.0.3,port=6380,state=online,offset=100,lag=1\r\n" +
+				"master_repl_offset:100\r\n",
+			role:     "master",
+			replicas: []string{"10.0.0.2:6379", "10.0.0.3:6380"},
+		},
+		{
+			name: "chained replica",
+			section: "# Replication\r\nrole:slave\r\nmaster_host:10.0.0.1\r\nmaster_port:6379\r\n" +
+				"slave_repl_offset:100\r\nslave_read_only:1\r\nslave_priority:100\r\nconnected_slaves:1\r\n" +
+				"slave0:ip=10.0.0.4,port=6379,state=online,offset=100,lag=0\r\n",
+			role:     "slave",
+			replicas: []string{"10.0.0.4:6379"},
+		},
+		{
+			name:     "ipv6",
+			section:  "role:master\r\nslave0:ip=fd00::2,port=6379,state=online,offset=0,lag=0\r\n",
+			role:     "master",
+			replicas: []string{"[fd00::2]:6379"},
+		},
+		{
+			name:    "missing port",
+			section: "role:master\r\nslave0:ip=10.0.0.2,state=online\r\n",
+			role:    "master",
+		},
+		{
+			name:    "no replica",
+			section: "# Replication\r\nrole:master\r\nconnected_slaves:0\r\n",
+			role:    "master",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, replicas := parseRedisReplicas(tt.section)
+			if role != tt.role {
+				t.Errorf("role = %q, want %q", role, tt.role)
+			}
+			if !reflect.DeepEqual(replicas, tt.replicas) {
+				t.Errorf("replicas = %v, want %v", replicas, tt.replicas)
+			}
+		})
+	}
+}
+
+func TestParseRedisClusterNodes(t *testing.T) {
+	result := `07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004,replica-0.cache.internal slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected
+67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 127.0.0.1:30002@31002 master - 0 1426238316232 2 connected 5461-10922
+292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 127.0.0.1:30003@31003 master - 0 1426238318243 3 connected 10923-16383 [10923->-67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1]
+6ec23923021cf3ffec47632106199cb7f496ce01 127.0.0.1:30005@31005 slave 67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 0 1426238316232 5 connected
+824fe116063bc5fcf9f4ffd895bc17aee7731ac3 127.0.0.1:30006@31006 slave 292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 0 1426238317741 6 connected
+e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 myself,master - 0 0 1 connected 0-5460 [5461-<-67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1]
+a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0 127.0.0.1:30007@31007 master - 0 1426238316232 7 connected
+f0e1d2c3b4a5968778695a4b3c2d1e0f9a8b7c6d 127.0.0.1:30008@31008 slave a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0 0 1426238316232 7 connected
+0a1b2c3d4e5f60718293a4b5c6d7e8f901234567 :0@0 master,noaddr - 1426238310000 1426238309000 8 disconnected
+1b2c3d4e5f60718293a4b5c6d7e8f90123456789 127.0.0.1:30009@31009 handshake - 0 0 0 connected
+`
+
+	want := []RedisNode{
+		{Addr: "127.0.0.1:30004", Role: "slave", Shard: "0-5460", MasterID: "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca"},
+		{Addr: "127.0.0.1:30002", Role: "master", Shard: "5461-10922", MasterID: "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1"},
+		{Addr: "127.0.0.1:30003", Role: "master", Shard: "10923-16383", MasterID: "292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f"},
+		{Addr: "127.0.0.1:30005", Role: "slave", Shard: "5461-10922", MasterID: "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1"},
+		{Addr: "127.0.0.1:30006", Role: "slave", Shard: "10923-16383", MasterID: "292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f"},
+		{Addr: "127.0.0.1:30001", Role: "master", Shard: "0-5460", MasterID: "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca"},
+		// A master without slots, and its replica.
+		{Addr: "127.0.0.1:30007", Role: "master", MasterID: "a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"},
+		{Addr: "127.0.0.1:30008", Role: "slave", MasterID: "a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"},
+	}
+
+	got := parseRedisClusterNodes(result)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got nodes\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestGetRedisMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		server      []string
+		replication []string
+		mode        string
+		role        string
+	}{
+		{
+			name:        "standalone",
+			server:      []string{"# Server", "redis_version:7.2.0", "redis_mode:standalone"},
+			replication: []string{"# Replication", "role:master", "connected_slaves:0"},
+			mode:        "standalone",
+			role:        "master",
+		},
+		{
+			name:        "cluster replica",
+			server:      []string{"# Server", "redis_version:7.2.0", "redis_mode:cluster"},
+			replication: []string{"# Replication", "role:slave", "master_host:10.0.0.1"},
+			mode:        "cluster",
+			role:        "slave",
+		},
+		{
+			// INFO replication isn't asked to sentinels.
+			name:   "sentinel",
+			server: []string{"# Server", "redis_version:7.2.0", "redis_mode:sentinel"},
+			mode:   "sentinel",
+			role:   "sentinel",
+		},
+		{
+			// Versions before 3.0 have no redis_mode.
+			name:        "no redis_mode",
+			server:      []string{"# Server", "redis_version:2.8.24"},
+			replication: []string{"# Replication", "role:master"},
+			mode:        "standalone",
+			role:        "master",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := redistest.NewServer(t, func(args []string) string {
+				if !strings.EqualFold(args[0], "INFO") || len(args) < 2 {
+					return ""
+				}
+				switch strings.ToLower(args[1]) {
+				case "server":
+					return redistest.Info(tt.server...)
+				case "replication":
+					if tt.replication != nil {
+						return redistest.Info(tt.replication...)
+					}
+				}
+				return ""
+			})
+			rdb := redis.NewClient(&redis.Options{Addr: s.Addr})
+			defer rdb.Close()
+
+			mode, role, err := GetRedisMode(context.Background(), rdb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode != tt.mode || role != tt.role {
+				t.Errorf("got mode %q role %q, want mode %q role %q", mode, role, tt.mode, tt.role)
+			}
+		})
+	}
+}
+
+func TestGetRedisSentinelNodes(t *testing.T) {
+	var mu sync.Mutex
+	var asked []string
+	s := redistest.NewServer(t, func(args []string) string {
+		if !strings.EqualFold(args[0], "SENTINEL") || len(args) < 2 {
+			return ""
+		}
+		mu.Lock()
+		asked = append(asked, strings.Join(args[1:], " "))
+		mu.Unlock()
+
+		switch strings.ToUpper(args[1]) {
+		case "MASTERS":
+			return redistest.Array(
+				redistest.Map("name", "cache", "ip", "10.0.0.1", "port", "6379", "runid", "a1", "flags", "master"),
+				redistest.Map("name", "queue", "ip", "fd00::1", "port", "6380", "runid", "b1", "flags", "master"),
+			)
+		case "SLAVES":
+			if args[2] == "cache" {
+				return redistest.Array(
+					redistest.Map("name", "10.0.0.2:6379", "ip", "10.0.0.2", "port", "6379", "flags", "slave"),
+					redistest.Map("name", "10.0.0.3:6379", "ip", "10.0.0.3", "port", "6379", "flags", "slave"),
+				)
+			}
+			return redistest.Array()
+		}
+		return ""
+	})
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr})
+	defer rdb.Close()
+
+	nodes, err := GetRedisSentinelNodes(context.Background(), rdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RedisNode{
+		{Addr: "10.0.0.1:6379", Role: "master", Shard: "cache", MasterID: "a1"},
+		{Addr: "10.0.0.2:6379", Role: "slave", Shard: "cache", MasterID: "a1"},
+		{Addr: "10.0.0.3:6379", Role: "slave", Shard: "cache", MasterID: "a1"},
+		{Addr: "[fd00::1]:6380", Role: "master", Shard: "queue", MasterID: "b1"},
+	}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("got %+v, want %+v", nodes, want)
+	}
+	if want := []string{"MASTERS", "SLAVES cache", "SLAVES queue"}; !reflect.DeepEqual(asked, want) {
+		t.Errorf("asked %v, want %v", asked, want)
+	}
+}
+
+func TestRedisClusterKeySlot(t *testing.T) {
+	// The check value of CRC16/XMODEM, given by the redis cluster specification.
+	if got := crc16("123456789"); got != 0x31c3 {
+		t.Errorf("crc16 = %#x, want 0x31c3", got)
+	}
+
+	for key, want := range map[string]int{
+		"":    0,
+		"foo": 12182,
+		"bar": 5061,
+		// Only the hash tag is hashed.
+		"{user1000}.following": 3443,
+		"{user1000}.followers": 3443,
+		"user1000":             3443,
+		// Only the first hash tag counts, from the first { to the next }.
+		"foo{{bar}}zap": redisClusterKeySlot("{bar"),
+		"foo{bar}{zap}": redisClusterKeySlot("bar"),
+	} {
+		if got := redisClusterKeySlot(key); got != want {
+			t.Errorf("slot of %q = %d, want %d", key, got, want)
+		}
+	}
+	// An empty hash tag hashes the whole key.
+	if redisClusterKeySlot("foo{}{bar}") == redisClusterKeySlot("bar") {
+		t.Error("the hash tag after an empty one is used")
+	}
+}
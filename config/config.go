@@ -0,0 +1,201 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/xieyanke/redis_exporter/collector"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ReloadSuccess reports whether the last configuration reload succeeded.
+	ReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "redis_exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Redis exporter config loaded successfully.",
+	})
+	// ReloadSeconds is the timestamp of the last successful configuration reload.
+	ReloadSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "redis_exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+)
+
+// Config is the content of the exporter configuration file.
+type Config struct {
+	Labels  map[string]string `yaml:"labels"`
+	Targets []*Target         `yaml:"targets"`
+}
+
+// Target is a group of redis nodes scraped with the same settings.
+type Target struct {
+	Name         string            `yaml:"name"`
+	Addrs        []string          `yaml:"addrs"`
+	Mode         string            `yaml:"mode"`
+	Username     string            `yaml:"username"`
+	Password     string            `yaml:"password"`
+	PasswordFile string            `yaml:"password_file"`
+	TLS          *TLS              `yaml:"tls"`
+	Scrapers     []string          `yaml:"scrapers"`
+	Labels       map[string]string `yaml:"labels"`
+	KeyChecks    *KeyChecks        `yaml:"key_checks"`
+	// DiscoverReplicas and RecursiveReplicas override the replica discovery
+	// flags for the standalone nodes of the target.
+	DiscoverReplicas  *bool `yaml:"discover_replicas"`
+	RecursiveReplicas *bool `yaml:"discover_replicas_recursive"`
+}
+
+// TLS is the client TLS configuration of a target.
+type TLS struct {
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	CAFile             string `yaml:"ca_file"`
+	ServerName         string `yaml:"server_name"`
+	MinVersion         string `yaml:"min_version"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// KeyChecks overrides the key sampling flags for the nodes of a target.
+type KeyChecks struct {
+	SampleSize      int    `yaml:"sample_size"`
+	SampleMethod    string `yaml:"sample_method"`
+	TopN            int    `yaml:"top_n"`
+	PrefixDelimiter string `yaml:"prefix_delimiter"`
+	PrefixDepth     int    `yaml:"prefix_depth"`
+	PrefixRegex     string `yaml:"prefix_regex"`
+	MaxPrefixes     int    `yaml:"max_prefixes"`
+}
+
+// Load reads and validates the configuration file, unknown fields are rejected.
+func Load(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration, which doesn't need any redis connection.
+func (c *Config) Validate() error {
+	if len(c.Targets) == 0 {
+		return errors.New("no target configured")
+	}
+	if err := ValidateLabels(c.Labels); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(c.Targets))
+	for i, t := range c.Targets {
+		if t == nil {
+			return fmt.Errorf("target %d is empty", i)
+		}
+		if t.Name == "" {
+			return fmt.Errorf("target %d has no name", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate target name %q", t.Name)
+		}
+		names[t.Name] = true
+
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("target %q: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+func (t *Target) validate() error {
+	if len(t.Addrs) == 0 {
+		return errors.New("no addrs")
+	}
+
+	switch t.Mode {
+	case "":
+		t.Mode = "standalone"
+	case "standalone", "cluster", "sentinel", "auto":
+	default:
+		return fmt.Errorf("unknown mode %q", t.Mode)
+	}
+
+	if t.Password != "" && t.PasswordFile != "" {
+		return errors.New("password and password_file are mutually exclusive")
+	}
+
+	if err := ValidateLabels(t.Labels); err != nil {
+		return err
+	}
+
+	if t.TLS != nil {
+		if (t.TLS.CertFile == "") != (t.TLS.KeyFile == "") {
+			return errors.New("both tls cert_file and key_file are required for client authentication")
+		}
+		if t.TLS.MinVersion == "" {
+			t.TLS.MinVersion = "TLS12"
+		}
+	}
+
+	if t.KeyChecks != nil {
+		switch t.KeyChecks.SampleMethod {
+		case "", "randomkey", "scan":
+		default:
+			return fmt.Errorf("unknown key_checks sample_method %q", t.KeyChecks.SampleMethod)
+		}
+		if t.KeyChecks.SampleSize < 0 || t.KeyChecks.TopN < 0 || t.KeyChecks.PrefixDepth < 0 || t.KeyChecks.MaxPrefixes < 0 {
+			return errors.New("key_checks values must not be negative")
+		}
+		if _, err := regexp.Compile(t.KeyChecks.PrefixRegex); err != nil {
+			return fmt.Errorf("invalid key_checks prefix_regex: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ValidateLabels checks that labels can be added to every metric of the exporter.
+func ValidateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if name == "target" || collector.IsReservedLabel(name) {
+			return fmt.Errorf("label name %q is reserved", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			content: `
+labels:
+  env: prod
+targets:
+  - name: cache
+    addrs: ["10.0.0.1:6379", "dns+cache.internal:6379"]
+    tls:
+      ca_file: /etc/redis/ca.pem
+    labels:
+      team: platform
+    key_checks:
+      sample_method: scan
+      prefix_regex: '^(\w+):'
+  - name: sessions
+    addrs: ["rediss://10.0.0.2:6380"]
+    mode: cluster
+    password_file: /etc/redis/password
+`,
+		},
+		{name: "empty", content: "", wantErr: "no target configured"},
+		{name: "unknown field", content: "targets:\n  - name: cache\n    adrs: [\"10.0.0.1:6379\"]\n", wantErr: "field adrs not found"},
+		{name: "no name", content: "targets:\n  - addrs: [\"10.0.0.1:6379\"]\n", wantErr: "has no name"},
+		{name: "empty target", content: "targets:\n  -\n", wantErr: "target 0 is empty"},
+		{
+			name:    "duplicate name",
+			content: "targets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n  - name: cache\n    addrs: [\"10.0.0.2:6379\"]\n",
+			wantErr: `duplicate target name "cache"`,
+		},
+		{name: "no addrs", content: "targets:\n  - name: cache\n", wantErr: "no addrs"},
+		{name: "unknown mode", content: "targets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n    mode: replica\n", wantErr: `unknown mode "replica"`},
+		{
+			name:    "password and password file",
+			content: "targets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n    password: secret\n    password_file: /etc/redis/password\n",
+			wantErr: "mutually exclusive",
+		},
+		{name: "reserved global label", content: "labels:\n  addr: x\ntargets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n", wantErr: `label name "addr" is reserved`},
+		{name: "target label", content: "targets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n    labels:\n      target: x\n", wantErr: `label name "target" is reserved`},
+		{name: "internal label", content: "targets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n    labels:\n      __meta: x\n", wantErr: `invalid label name "__meta"`},
+		{name: "invalid label", content: "targets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n    labels:\n      team-name: x\n", wantErr: `invalid label name "team-name"`},
+		{
+			name:    "tls cert without key",
+			content: "targets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n    tls:\n      cert_file: /etc/redis/client.pem\n",
+			wantErr: "both tls cert_file and key_file",
+		},
+		{
+			name:    "unknown sample method",
+			content: "targets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n    key_checks:\n      sample_method: keys\n",
+			wantErr: `unknown key_checks sample_method "keys"`,
+		},
+		{
+			name:    "negative key checks",
+			content: "targets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n    key_checks:\n      top_n: -1\n",
+			wantErr: "must not be negative",
+		},
+		{
+			name:    "invalid prefix regex",
+			content: "targets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n    key_checks:\n      prefix_regex: '('\n",
+			wantErr: "invalid key_checks prefix_regex",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := Load(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestLoadDefaults checks the values filled in for the omitted settings.
+func TestLoadDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	content := "targets:\n  - name: cache\n    addrs: [\"10.0.0.1:6379\"]\n    tls:\n      ca_file: /etc/redis/ca.pem\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := cfg.Targets[0]
+	if target.Mode != "standalone" {
+		t.Errorf("mode = %q, want standalone", target.Mode)
+	}
+	if target.TLS.MinVersion != "TLS12" {
+		t.Errorf("tls min_version = %q, want TLS12", target.TLS.MinVersion)
+	}
+	if target.DiscoverReplicas != nil || target.RecursiveReplicas != nil {
+		t.Error("replica discovery overridden")
+	}
+}
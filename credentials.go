@@ -0,0 +1,159 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"gopkg.in/yaml.v3"
+)
+
+// watchedFile is a file read again from disk on the next access after it changes.
+type watchedFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	content []byte
+	loaded  bool
+}
+
+// read returns the content of the file and whether it changed since the last read.
+// The last content read is kept if the file can't be read anymore.
+func (f *watchedFile) read() ([]byte, bool, error) {
+	content, modTime, changed, err := f.load()
+	if err != nil || !changed {
+		return content, false, err
+	}
+	f.commit(content, modTime)
+	return content, true, nil
+}
+
+// load returns the content of the file, its modification time and whether it
+// changed since the last commit, which is left to the caller. The last content
+// committed is returned if the file can't be read anymore.
+func (f *watchedFile) load() ([]byte, time.Time, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return f.content, f.modTime, false, err
+	}
+	if f.loaded && fi.ModTime().Equal(f.modTime) {
+		return f.content, f.modTime, false, nil
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return f.content, f.modTime, false, err
+	}
+	return content, fi.ModTime(), true, nil
+}
+
+// commit records content as the last content read, at modTime.
+func (f *watchedFile) commit(content []byte, modTime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.content, f.modTime, f.loaded = content, modTime, true
+}
+
+// credentials are the username and password of the targets matching Addr,
+// which is a shell pattern such as `10.0.1.*:6379`.
+type credentials struct {
+	Addr     string `yaml:"addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type credentialsFileContent struct {
+	Credentials []credentials `yaml:"credentials"`
+}
+
+// credentialsStore resolves the credentials of a target from the password file
+// and the credentials file, so rotations take effect on the next scrape.
+type credentialsStore struct {
+	logger          log.Logger
+	passwdFile      *watchedFile
+	credentialsFile *watchedFile
+
+	mu          sync.Mutex
+	credentials []credentials
+}
+
+func newCredentialsStore(passwdFile, credentialsFile string, logger log.Logger) *credentialsStore {
+	s := &credentialsStore{logger: logger}
+	if passwdFile != "" {
+		s.passwdFile = &watchedFile{path: passwdFile}
+	}
+	if credentialsFile != "" {
+		s.credentialsFile = &watchedFile{path: credentialsFile}
+	}
+	return s
+}
+
+// password returns the default password, read from the password file if any.
+func (s *credentialsStore) password() string {
+	if s == nil || s.passwdFile == nil {
+		return *passwd
+	}
+
+	content, _, err := s.passwdFile.read()
+	if err != nil {
+		level.Error(s.logger).Log("msg", "Error reading password file", "file", s.passwdFile.path, "err", err)
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// lookup returns the credentials of the first entry of the credentials file
+// matching addr.
+func (s *credentialsStore) lookup(addr string) (credentials, bool) {
+	if s == nil || s.credentialsFile == nil {
+		return credentials{}, false
+	}
+
+	content, changed, err := s.credentialsFile.read()
+	if err != nil {
+		level.Error(s.logger).Log("msg", "Error reading credentials file", "file", s.credentialsFile.path, "err", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if changed {
+		var c credentialsFileContent
+		if err := yaml.Unmarshal(content, &c); err != nil {
+			level.Error(s.logger).Log("msg", "Error parsing credentials file", "file", s.credentialsFile.path, "err", err)
+		} else {
+			s.credentials = c.Credentials
+			level.Info(s.logger).Log("msg", "Loaded credentials file", "file", s.credentialsFile.path, "entries", len(c.Credentials))
+		}
+	}
+
+	for _, c := range s.credentials {
+		if ok, _ := path.Match(c.Addr, addr); ok {
+			return c, true
+		}
+	}
+	return credentials{}, false
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/xieyanke/redis_exporter/collector"
+)
+
+var replicationTopologyInfo = prometheus.NewDesc(
+	prometheus.BuildFQName(collector.Namespace, "replication", "topology_info"),
+	"Replication link from a master to a replica found by the replica discovery.",
+	[]string{"master", "replica"},
+	nil,
+)
+
+// replicationLink is a master to replica link.
+type replicationLink struct {
+	master  string
+	replica string
+}
+
+// redisNodes are the nodes of a target found by the discovery.
+type redisNodes struct {
+	opts []*redis.Options
+	// nodes describe the nodes of opts, in the same order.
+	nodes []collector.RedisNode
+	// mode is the mode of the nodes, detected from the seeds in auto mode.
+	mode string
+	// links are the replication links found by the replica discovery.
+	links []replicationLink
+}
+
+// Describe implements prometheus.Collector.
+func (n *redisNodes) Describe(ch chan<- *prometheus.Desc) {
+	ch <- replicationTopologyInfo
+}
+
+// Collect implements prometheus.Collector, it exports the replication topology.
+func (n *redisNodes) Collect(ch chan<- prometheus.Metric) {
+	for _, link := range n.links {
+		ch <- prometheus.MustNewConstMetric(replicationTopologyInfo, prometheus.GaugeValue, 1, link.master, link.replica)
+	}
+}
+
+// newRedisNodes discovers the redis nodes to scrape from the seed addresses of
+// t. The nodes of a sentinel are the masters it monitors and their replicas.
+func newRedisNodes(ctx context.Context, t *target, logger log.Logger) *redisNodes {
+	nodes := findRedisNodes(ctx, t, logger)
+	t.setNodes(nodes)
+	return nodes
+}
+
+// cachedRedisNodes returns the nodes of the last discovery of t if it is
+// younger than maxAge, the nodes discovered again otherwise.
+func cachedRedisNodes(ctx context.Context, t *target, maxAge time.Duration, logger log.Logger) *redisNodes {
+	if nodes := t.lastNodes(maxAge); nodes != nil {
+		return nodes
+	}
+	return newRedisNodes(ctx, t, logger)
+}
+
+func findRedisNodes(ctx context.Context, t *target, logger log.Logger) *redisNodes {
+	var seeds []*redis.Options
+	seen := make(map[string]bool)
+	for _, addr := range t.seedAddrs(ctx) {
+		opt, err := t.newRedisOptions(addr)
+		if err != nil {
+			level.Error(logger).Log("msg", "Invalid redis address", "addr", redactAddr(addr), "err", err)
+			continue
+		}
+		// A node listed twice would export its metrics twice.
+		if seen[opt.Addr] {
+			continue
+		}
+		seen[opt.Addr] = true
+		seeds = append(seeds, opt)
+	}
+	if len(seeds) == 0 {
+		return &redisNodes{mode: t.mode}
+	}
+
+	// Standalone nodes are scraped as given, the scrape handles the
+	// unreachable ones.
+	if t.mode == "standalone" {
+		return t.withReplicas(ctx, newStandaloneNodes(seeds), logger)
+	}
+
+	for _, seed := range seeds {
+		rdb := collector.NewClient(seed)
+		nodes, err := discoverNodes(ctx, rdb, t.mode, seeds, logger)
+		rdb.Close()
+		if err != nil {
+			level.Error(logger).Log("msg", fmt.Sprintf("%s can't discover the redis nodes", seed.Addr), "mode", t.mode, "err", err)
+			continue
+		}
+		if nodes.mode == "standalone" {
+			return t.withReplicas(ctx, nodes, logger)
+		}
+		return nodes
+	}
+
+	return &redisNodes{mode: t.mode}
+}
+
+// discoverNodes returns the nodes to scrape found from the seed rdb.
+func discoverNodes(ctx context.Context, rdb *redis.Client, mode string, seeds []*redis.Options, logger log.Logger) (*redisNodes, error) {
+	seed := rdb.Options()
+
+	if mode == "auto" {
+		var role string
+		var err error
+		mode, role, err = collector.GetRedisMode(ctx, rdb)
+		if err != nil {
+			return nil, err
+		}
+		level.Debug(logger).Log("msg", "Detected redis mode", "addr", seed.Addr, "mode", mode, "role", role)
+	}
+
+	var found []collector.RedisNode
+	var err error
+	switch mode {
+	case "cluster":
+		found, err = collector.GetRedisClusterNodes(ctx, rdb)
+	case "sentinel":
+		found, err = collector.GetRedisSentinelNodes(ctx, rdb)
+	default:
+		nodes := newStandaloneNodes(seeds)
+		nodes.mode = mode
+		return nodes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := &redisNodes{mode: mode, nodes: found}
+	for _, node := range found {
+		nodes.opts = append(nodes.opts, newNodeOptions(seed, node.Addr))
+	}
+	return nodes, nil
+}
+
+// newStandaloneNodes returns the seeds as standalone nodes, whose role is
+// unknown until their replicas are discovered.
+func newStandaloneNodes(seeds []*redis.Options) *redisNodes {
+	nodes := &redisNodes{opts: seeds, mode: "standalone"}
+	for _, seed := range seeds {
+		nodes.nodes = append(nodes.nodes, collector.RedisNode{Addr: seed.Addr})
+	}
+	return nodes
+}
+
+// filter returns the nodes whose address is addr, all of them if it is empty.
+func (n *redisNodes) filter(addr string) *redisNodes {
+	if addr == "" {
+		return n
+	}
+
+	filtered := &redisNodes{mode: n.mode}
+	for i, opt := range n.opts {
+		if opt.Addr == addr {
+			filtered.opts = append(filtered.opts, opt)
+			filtered.nodes = append(filtered.nodes, n.nodes[i])
+		}
+	}
+	for _, link := range n.links {
+		if link.master == addr || link.replica == addr {
+			filtered.links = append(filtered.links, link)
+		}
+	}
+	return filtered
+}
+
+// withReplicas adds to the standalone nodes the replicas of the masters among
+// them, and of those replicas when the discovery is recursive.
+func (t *target) withReplicas(ctx context.Context, nodes *redisNodes, logger log.Logger) *redisNodes {
+	if !t.discoverReplicas {
+		return nodes
+	}
+
+	index := make(map[string]int, len(nodes.opts))
+	for i, opt := range nodes.opts {
+		index[opt.Addr] = i
+	}
+
+	linked := make(map[replicationLink]bool)
+	queue := append([]*redis.Options{}, nodes.opts...)
+	for len(queue) > 0 {
+		opt := queue[0]
+		queue = queue[1:]
+
+		rdb := collector.NewClient(opt)
+		role, replicas, err := collector.GetRedisReplicas(ctx, rdb)
+		rdb.Close()
+		if err != nil {
+			level.Error(logger).Log("msg", fmt.Sprintf("%s can't list its replicas", opt.Addr), "err", err)
+			continue
+		}
+
+		i := index[opt.Addr]
+		nodes.nodes[i].Role = role
+		if role == "master" {
+			nodes.nodes[i].Shard, nodes.nodes[i].MasterID = opt.Addr, opt.Addr
+		}
+		shard := nodes.nodes[i].Shard
+		// Only masters are followed among the seeds, chained replicas only
+		// when the discovery is recursive.
+		if role != "master" && !t.recursiveReplicas {
+			continue
+		}
+
+		for _, addr := range replicas {
+			link := replicationLink{master: opt.Addr, replica: addr}
+			if linked[link] {
+				continue
+			}
+			linked[link] = true
+			nodes.links = append(nodes.links, link)
+			if _, ok := index[addr]; ok {
+				continue
+			}
+			index[addr] = len(nodes.opts)
+
+			replica := newNodeOptions(opt, addr)
+			nodes.opts = append(nodes.opts, replica)
+			nodes.nodes = append(nodes.nodes, collector.RedisNode{
+				Addr:     addr,
+				Role:     "slave",
+				Shard:    shard,
+				MasterID: opt.Addr,
+			})
+			if t.recursiveReplicas {
+				queue = append(queue, replica)
+			}
+		}
+	}
+
+	return nodes
+}
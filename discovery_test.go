@@ -0,0 +1,242 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/xieyanke/redis_exporter/collector"
+	"github.com/xieyanke/redis_exporter/internal/redistest"
+)
+
+// newFakeNode returns a standalone node of role listing replicas in INFO
+// replication.
+func newFakeNode(t *testing.T, role string, replicas ...*redistest.Server) *redistest.Server {
+	lines := []string{"# Replication", "role:" + role}
+	if role == "slave" {
+		lines = append(lines, "master_host:127.0.0.1", "slave_repl_offset:0", "slave_read_only:1")
+	}
+	lines = append(lines, fmt.Sprintf("connected_slaves:%d", len(replicas)))
+	for i, r := range replicas {
+		lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%d,state=online,offset=0,lag=0", i, r.Host, r.Port))
+	}
+
+	return redistest.NewServer(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			return redistest.Status("PONG")
+		case "CLIENT":
+			return redistest.Status("OK")
+		case "INFO":
+			if len(args) > 1 && strings.EqualFold(args[1], "server") {
+				return redistest.Info("# Server", "redis_version:7.2.0", "redis_mode:standalone")
+			}
+			return redistest.Info(lines...)
+		}
+		return ""
+	})
+}
+
+// newFakeModeNode returns a node whose INFO server reports mode, answering
+// the CLUSTER NODES and SENTINEL commands with fixed nodes. Every command it
+// receives is sent on commands when it isn't nil.
+func newFakeModeNode(t *testing.T, mode string, commands chan<- string) *redistest.Server {
+	return redistest.NewServer(t, func(args []string) string {
+		if commands != nil {
+			commands <- strings.ToLower(strings.Join(args, " "))
+		}
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			return redistest.Status("PONG")
+		case "CLIENT", "ACL":
+			return redistest.Status("OK")
+		case "INFO":
+			if len(args) > 1 && strings.EqualFold(args[1], "server") {
+				return redistest.Info("# Server", "redis_version:7.2.0", "redis_mode:"+mode)
+			}
+			return redistest.Info("# Replication", "role:master", "connected_slaves:0")
+		case "CLUSTER":
+			return redistest.Bulk("a1 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-16383\n" +
+				"b1 10.0.0.2:6379@16379 slave a1 0 0 1 connected\n")
+		case "SENTINEL":
+			if strings.EqualFold(args[1], "MASTERS") {
+				return redistest.Array(redistest.Map("name", "cache", "ip", "10.0.0.1", "port", "6379", "runid", "a1"))
+			}
+			return redistest.Array(redistest.Map("ip", "10.0.0.2", "port", "6379"))
+		}
+		return ""
+	})
+}
+
+// TestDiscoverAutoMode checks that the nodes of an auto target are discovered
+// in the mode its seed reports.
+func TestDiscoverAutoMode(t *testing.T) {
+	for _, tt := range []struct {
+		mode  string
+		nodes []string
+	}{
+		{mode: "standalone"},
+		{mode: "cluster", nodes: []string{"10.0.0.1:6379", "10.0.0.2:6379"}},
+		{mode: "sentinel", nodes: []string{"10.0.0.1:6379", "10.0.0.2:6379"}},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			seed := newFakeModeNode(t, tt.mode, nil)
+			seeds := []*redis.Options{{Addr: seed.Addr}}
+			rdb := collector.NewClient(seeds[0])
+			defer rdb.Close()
+
+			nodes, err := discoverNodes(context.Background(), rdb, "auto", seeds, log.NewNopLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nodes.mode != tt.mode {
+				t.Errorf("mode = %q, want %q", nodes.mode, tt.mode)
+			}
+			want := tt.nodes
+			if want == nil {
+				want = []string{seed.Addr}
+			}
+			if got := nodeAddrs(nodes); !reflect.DeepEqual(got, want) {
+				t.Errorf("nodes = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func nodeAddrs(nodes *redisNodes) []string {
+	var addrs []string
+	for _, opt := range nodes.opts {
+		addrs = append(addrs, opt.Addr)
+	}
+	return addrs
+}
+
+// TestReplicaDiscovery checks the nodes and the replication links found from
+// the seeds of a master, a replica and its chained replica.
+func TestReplicaDiscovery(t *testing.T) {
+	chained := newFakeNode(t, "slave")
+	replica := newFakeNode(t, "slave", chained)
+	master := newFakeNode(t, "master", replica)
+
+	tests := []struct {
+		name      string
+		seeds     []string
+		mode      string
+		recursive bool
+		nodes     []collector.RedisNode
+		links     []replicationLink
+	}{
+		{
+			name:  "master",
+			seeds: []string{master.Addr, master.Addr},
+			nodes: []collector.RedisNode{
+				{Addr: master.Addr, Role: "master", Shard: master.Addr, MasterID: master.Addr},
+				{Addr: replica.Addr, Role: "slave", Shard: master.Addr, MasterID: master.Addr},
+			},
+			links: []replicationLink{{master: master.Addr, replica: replica.Addr}},
+		},
+		{
+			name:      "recursive",
+			seeds:     []string{master.Addr},
+			recursive: true,
+			nodes: []collector.RedisNode{
+				{Addr: master.Addr, Role: "master", Shard: master.Addr, MasterID: master.Addr},
+				{Addr: replica.Addr, Role: "slave", Shard: master.Addr, MasterID: master.Addr},
+				{Addr: chained.Addr, Role: "slave", Shard: master.Addr, MasterID: replica.Addr},
+			},
+			links: []replicationLink{
+				{master: master.Addr, replica: replica.Addr},
+				{master: replica.Addr, replica: chained.Addr},
+			},
+		},
+		{
+			name:  "replica seed",
+			seeds: []string{replica.Addr},
+			nodes: []collector.RedisNode{{Addr: replica.Addr, Role: "slave"}},
+		},
+		{
+			name:      "auto",
+			seeds:     []string{master.Addr},
+			mode:      "auto",
+			recursive: true,
+			nodes: []collector.RedisNode{
+				{Addr: master.Addr, Role: "master", Shard: master.Addr, MasterID: master.Addr},
+				{Addr: replica.Addr, Role: "slave", Shard: master.Addr, MasterID: master.Addr},
+				{Addr: chained.Addr, Role: "slave", Shard: master.Addr, MasterID: replica.Addr},
+			},
+			links: []replicationLink{
+				{master: master.Addr, replica: replica.Addr},
+				{master: replica.Addr, replica: chained.Addr},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode := tt.mode
+			if mode == "" {
+				mode = "standalone"
+			}
+			tg := &target{
+				addrs:             tt.seeds,
+				mode:              mode,
+				discoverReplicas:  true,
+				recursiveReplicas: tt.recursive,
+				logger:            log.NewNopLogger(),
+			}
+
+			nodes := newRedisNodes(context.Background(), tg, tg.logger)
+			if nodes.mode != "standalone" {
+				t.Errorf("mode = %q, want standalone", nodes.mode)
+			}
+			if !reflect.DeepEqual(nodes.nodes, tt.nodes) {
+				t.Errorf("nodes = %+v, want %+v", nodes.nodes, tt.nodes)
+			}
+			if len(nodes.opts) != len(tt.nodes) {
+				t.Errorf("got %d node options, want %d", len(nodes.opts), len(tt.nodes))
+			}
+			if !reflect.DeepEqual(nodes.links, tt.links) {
+				t.Errorf("links = %+v, want %+v", nodes.links, tt.links)
+			}
+
+			registry := prometheus.NewRegistry()
+			if err := registry.Register(nodes); err != nil {
+				t.Fatal(err)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var exported int
+			for _, mf := range mfs {
+				if mf.GetName() == "redis_replication_topology_info" {
+					exported = len(mf.GetMetric())
+				}
+			}
+			if exported != len(tt.links) {
+				t.Errorf("exported %d links, want %d", exported, len(tt.links))
+			}
+		})
+	}
+}
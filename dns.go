@@ -0,0 +1,196 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	dnsSRVPrefix = "dnssrv+"
+	dnsPrefix    = "dns+"
+
+	// dnsLookupTimeout bounds a lookup, which doesn't stop when the caller
+	// starting it gives up since other callers may be waiting for it.
+	dnsLookupTimeout = 10 * time.Second
+)
+
+var (
+	dnsDiscoveryAddresses = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "redis_exporter",
+		Name:      "dns_discovery_addresses",
+		Help:      "Number of addresses returned by the last resolution of a DNS target address.",
+	}, []string{"name"})
+	dnsDiscoveryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "redis_exporter",
+		Name:      "dns_discovery_errors_total",
+		Help:      "Number of failed resolutions of a DNS target address.",
+	}, []string{"name"})
+)
+
+// resolver looks up the DNS records of the target addresses, *net.Resolver
+// outside of the tests.
+type resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// isDNSAddr reports whether addr is resolved with DNS, as
+// `dnssrv+<srv name>` or `dns+<host>:<port>`.
+func isDNSAddr(addr string) bool {
+	return strings.HasPrefix(addr, dnsSRVPrefix) || strings.HasPrefix(addr, dnsPrefix)
+}
+
+// validateDNSAddr checks the syntax of a DNS address, without resolving it.
+func validateDNSAddr(addr string) error {
+	if name := strings.TrimPrefix(addr, dnsSRVPrefix); name != addr {
+		if name == "" {
+			return fmt.Errorf("invalid DNS address %q: missing SRV name", addr)
+		}
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(addr, dnsPrefix))
+	if err != nil {
+		return fmt.Errorf("invalid DNS address %q: %w", addr, err)
+	}
+	if host == "" || port == "" {
+		return fmt.Errorf("invalid DNS address %q: missing host or port", addr)
+	}
+	return nil
+}
+
+type dnsEntry struct {
+	addrs []string
+	err   error
+	time  time.Time
+}
+
+// dnsDiscovery resolves the DNS addresses of the targets, again once refresh
+// has elapsed since their last resolution. A name failing to resolve is tried
+// again after refresh too, the last addresses found are kept meanwhile.
+type dnsDiscovery struct {
+	resolver resolver
+	refresh  time.Duration
+	logger   log.Logger
+	group    singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+func newDNSDiscovery(r resolver, refresh time.Duration, logger log.Logger) *dnsDiscovery {
+	return &dnsDiscovery{
+		resolver: r,
+		refresh:  refresh,
+		logger:   logger,
+		entries:  make(map[string]*dnsEntry),
+	}
+}
+
+// resolve returns the addresses of a DNS address, host:port pairs sorted to
+// keep the order of the nodes stable. The concurrent resolutions of a name
+// share a single lookup, a caller giving up on ctx doesn't fail it for the
+// others.
+func (d *dnsDiscovery) resolve(ctx context.Context, addr string) ([]string, error) {
+	d.mu.Lock()
+	entry, ok := d.entries[addr]
+	d.mu.Unlock()
+	if ok && time.Since(entry.time) < d.refresh {
+		return entry.addrs, entry.err
+	}
+
+	ch := d.group.DoChan(addr, func() (interface{}, error) {
+		lookupCtx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+		defer cancel()
+
+		addrs, err := d.lookup(lookupCtx, addr)
+		if err != nil {
+			dnsDiscoveryErrors.WithLabelValues(addr).Inc()
+			if ok && entry.addrs != nil {
+				level.Warn(d.logger).Log("msg", "Error resolving DNS address, using the last addresses found", "name", addr, "err", err)
+				addrs, err = entry.addrs, nil
+			}
+		} else {
+			dnsDiscoveryAddresses.WithLabelValues(addr).Set(float64(len(addrs)))
+		}
+
+		entry := &dnsEntry{addrs: addrs, err: err, time: time.Now()}
+		d.mu.Lock()
+		d.entries[addr] = entry
+		d.mu.Unlock()
+		return entry, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		entry = res.Val.(*dnsEntry)
+		return entry.addrs, entry.err
+	}
+}
+
+// forget drops the addresses and the metrics of a DNS address which isn't
+// used by any target anymore.
+func (d *dnsDiscovery) forget(addr string) {
+	d.mu.Lock()
+	delete(d.entries, addr)
+	d.mu.Unlock()
+
+	dnsDiscoveryAddresses.DeleteLabelValues(addr)
+	dnsDiscoveryErrors.DeleteLabelValues(addr)
+}
+
+func (d *dnsDiscovery) lookup(ctx context.Context, addr string) ([]string, error) {
+	if err := validateDNSAddr(addr); err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	if name := strings.TrimPrefix(addr, dnsSRVPrefix); name != addr {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range records {
+			addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+	} else {
+		host, port, _ := net.SplitHostPort(strings.TrimPrefix(addr, dnsPrefix))
+		hosts, err := d.resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hosts {
+			addrs = append(addrs, net.JoinHostPort(h, port))
+		}
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
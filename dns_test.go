@@ -0,0 +1,365 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/xieyanke/redis_exporter/collector"
+)
+
+// stubResolver answers the lookups from fixed records, or fails them with err.
+// The host lookups are counted, and wait for unblock when it isn't nil or
+// until their ctx is done.
+type stubResolver struct {
+	mu      sync.Mutex
+	srv     map[string][]*net.SRV
+	hosts   map[string][]string
+	err     error
+	lookups int
+	unblock chan struct{}
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return name, r.srv[name], nil
+}
+
+func (r *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	r.lookups++
+	unblock := r.unblock
+	r.mu.Unlock()
+	if unblock != nil {
+		select {
+		case <-unblock:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.hosts[host], nil
+}
+
+func (r *stubResolver) set(hosts map[string][]string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts, r.err = hosts, err
+}
+
+func (r *stubResolver) lookupCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups
+}
+
+func gaugeValue(t *testing.T, name string) float64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	if err := dnsDiscoveryAddresses.WithLabelValues(name).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestValidateDNSAddr(t *testing.T) {
+	for addr, valid := range map[string]bool{
+		"dnssrv+_redis._tcp.cache.internal": true,
+		"dnssrv+":                           false,
+		"dns+redis.internal:6379":           true,
+		"dns+redis.internal":                false,
+		"dns+:6379":                         false,
+	} {
+		if err := validateDNSAddr(addr); (err == nil) != valid {
+			t.Errorf("validateDNSAddr(%q) = %v, want valid %v", addr, err, valid)
+		}
+	}
+}
+
+func TestDNSDiscoverySRV(t *testing.T) {
+	r := &stubResolver{srv: map[string][]*net.SRV{
+		"_redis._tcp.cache.internal": {
+			{Target: "redis-1.cache.internal.", Port: 6380},
+			{Target: "redis-0.cache.internal.", Port: 6379},
+		},
+	}}
+	d := newDNSDiscovery(r, time.Minute, log.NewNopLogger())
+
+	name := "dnssrv+_redis._tcp.cache.internal"
+	addrs, err := d.resolve(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"redis-0.cache.internal:6379", "redis-1.cache.internal:6380"}
+	if !reflect.DeepEqual(addrs, want) {
+		t.Errorf("got %v, want %v", addrs, want)
+	}
+	if got := gaugeValue(t, name); got != 2 {
+		t.Errorf("%s addresses = %v, want 2", name, got)
+	}
+}
+
+// TestDNSDiscoveryRefresh checks that a name is resolved again once the
+// refresh interval has elapsed, and that the last addresses found are kept
+// while it fails to resolve.
+func TestDNSDiscoveryRefresh(t *testing.T) {
+	r := &stubResolver{hosts: map[string][]string{"redis.internal": {"10.0.0.2", "10.0.0.1"}}}
+	d := newDNSDiscovery(r, 50*time.Millisecond, log.NewNopLogger())
+	name := "dns+redis.internal:6379"
+
+	steps := []struct {
+		hosts []string
+		err   error
+		want  []string
+	}{
+		{hosts: []string{"10.0.0.2", "10.0.0.1"}, want: []string{"10.0.0.1:6379", "10.0.0.2:6379"}},
+		{hosts: []string{"10.0.0.3"}, want: []string{"10.0.0.3:6379"}},
+		{err: errors.New("server misbehaving"), want: []string{"10.0.0.3:6379"}},
+	}
+	for i, step := range steps {
+		if i > 0 {
+			time.Sleep(60 * time.Millisecond)
+		}
+		r.set(map[string][]string{"redis.internal": step.hosts}, step.err)
+
+		addrs, err := d.resolve(context.Background(), name)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(addrs, step.want) {
+			t.Errorf("step %d: got %v, want %v", i, addrs, step.want)
+		}
+	}
+
+	// The failed name isn't looked up again within the refresh interval.
+	lookups := r.lookupCount()
+	r.set(map[string][]string{"redis.internal": {"10.0.0.4"}}, nil)
+	addrs, err := d.resolve(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.3:6379"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("got %v, want %v", addrs, want)
+	}
+	if got := r.lookupCount(); got != lookups {
+		t.Errorf("lookups = %d, want %d", got, lookups)
+	}
+}
+
+// TestDNSDiscoveryFailure checks that a name which never resolved fails
+// without being looked up again until the refresh interval elapsed.
+func TestDNSDiscoveryFailure(t *testing.T) {
+	r := &stubResolver{err: errors.New("no such host")}
+	d := newDNSDiscovery(r, 50*time.Millisecond, log.NewNopLogger())
+	name := "dns+missing.internal:6379"
+
+	for i := 0; i < 2; i++ {
+		if _, err := d.resolve(context.Background(), name); err == nil {
+			t.Fatalf("call %d: resolved a missing name", i)
+		}
+	}
+	if got := r.lookupCount(); got != 1 {
+		t.Errorf("lookups = %d, want 1", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	r.set(map[string][]string{"missing.internal": {"10.0.0.1"}}, nil)
+	addrs, err := d.resolve(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1:6379"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("got %v, want %v", addrs, want)
+	}
+}
+
+// TestDNSDiscoveryConcurrent checks that concurrent resolutions of a name
+// share a single lookup.
+func TestDNSDiscoveryConcurrent(t *testing.T) {
+	unblock := make(chan struct{})
+	r := &stubResolver{hosts: map[string][]string{"redis.internal": {"10.0.0.1"}}, unblock: unblock}
+	d := newDNSDiscovery(r, time.Minute, log.NewNopLogger())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := d.resolve(context.Background(), "dns+redis.internal:6379"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// Let the resolutions pile up behind the first lookup.
+	for r.lookupCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+
+	if got := r.lookupCount(); got != 1 {
+		t.Errorf("lookups = %d, want 1", got)
+	}
+}
+
+// TestDNSDiscoveryCanceled checks that the caller starting a lookup doesn't
+// fail it for the others when it gives up, and that its own failure is neither
+// counted nor cached.
+func TestDNSDiscoveryCanceled(t *testing.T) {
+	unblock := make(chan struct{})
+	r := &stubResolver{hosts: map[string][]string{"canceled.internal": {"10.0.0.1"}}, unblock: unblock}
+	d := newDNSDiscovery(r, time.Minute, log.NewNopLogger())
+	name := "dns+canceled.internal:6379"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := d.resolve(ctx, name)
+		first <- err
+	}()
+	for r.lookupCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	second := make(chan []string, 1)
+	go func() {
+		addrs, err := d.resolve(context.Background(), name)
+		if err != nil {
+			t.Error(err)
+		}
+		second <- addrs
+	}()
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("first caller err = %v, want %v", err, context.Canceled)
+	}
+	close(unblock)
+	if addrs, want := <-second, []string{"10.0.0.1:6379"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("second caller got %v, want %v", addrs, want)
+	}
+
+	m := &dto.Metric{}
+	if err := dnsDiscoveryErrors.WithLabelValues(name).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 0 {
+		t.Errorf("errors = %v, want 0", got)
+	}
+	if got := r.lookupCount(); got != 1 {
+		t.Errorf("lookups = %d, want 1", got)
+	}
+}
+
+// TestDNSDiscoveryForget checks that the series of a DNS address are dropped
+// once no target uses it anymore.
+func TestDNSDiscoveryForget(t *testing.T) {
+	r := &stubResolver{hosts: map[string][]string{"redis.internal": {"10.0.0.1"}}}
+	dnsResolver = newDNSDiscovery(r, time.Minute, log.NewNopLogger())
+	defer func() { dnsResolver = nil }()
+	oldScheduler := scheduler
+	scheduler = collector.NewScheduler(1, 1)
+	defer func() { scheduler = oldScheduler }()
+
+	name := "dns+redis.internal:6379"
+	newTarget := func(targetName string) *target {
+		return &target{name: targetName, addrs: []string{name}, mode: "standalone", logger: log.NewNopLogger()}
+	}
+	a, b := newTarget("a"), newTarget("b")
+	useTargets(t, a, b)
+	if _, err := dnsResolver.resolve(context.Background(), name); err != nil {
+		t.Fatal(err)
+	}
+
+	targets.set([]*target{b})
+	if !hasSeries(dnsDiscoveryAddresses, name) {
+		t.Fatalf("%s addresses dropped while a target uses it", name)
+	}
+	targets.set(nil)
+	if hasSeries(dnsDiscoveryAddresses, name) {
+		t.Errorf("%s addresses kept after its targets were removed", name)
+	}
+}
+
+// hasSeries reports whether c collects a series with the name label value.
+func hasSeries(c prometheus.Collector, value string) bool {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	found := false
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			continue
+		}
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == "name" && l.GetValue() == value {
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// TestTargetDNSNodes checks that every resolved address is a node of the
+// target, next to its plain addresses.
+func TestTargetDNSNodes(t *testing.T) {
+	r := &stubResolver{hosts: map[string][]string{"redis.internal": {"10.0.0.2", "10.0.0.1"}}}
+	dnsResolver = newDNSDiscovery(r, time.Minute, log.NewNopLogger())
+	defer func() { dnsResolver = nil }()
+
+	tg := &target{
+		addrs:  []string{"10.0.1.1:6379", "dns+redis.internal:6379"},
+		mode:   "standalone",
+		logger: log.NewNopLogger(),
+	}
+	if err := tg.validateAddrs(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, opt := range newRedisNodes(context.Background(), tg, tg.logger).opts {
+		got = append(got, opt.Addr)
+	}
+	want := []string{"10.0.1.1:6379", "10.0.0.1:6379", "10.0.0.2:6379"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got nodes %v, want %v", got, want)
+	}
+}
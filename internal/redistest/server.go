@@ -0,0 +1,180 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package redistest provides a fake RESP2 redis server for the tests.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Handler returns the RESP2 encoded reply to a command.
+type Handler func(args []string) string
+
+// Server is a fake redis server listening on a local port, closed when the
+// test ends.
+type Server struct {
+	Addr string
+	// Host and Port are the parts of Addr.
+	Host string
+	Port int
+	// Done is closed when the test ends, for the handlers which block.
+	Done chan struct{}
+
+	ln      net.Listener
+	handler Handler
+}
+
+// NewServer starts a server answering the commands with handler. HELLO is
+// refused unless handler answers it, so the clients fall back to RESP2.
+func NewServer(t testing.TB, handler Handler) *Server {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpAddr := ln.Addr().(*net.TCPAddr)
+	s := &Server{
+		Addr:    ln.Addr().String(),
+		Host:    tcpAddr.IP.String(),
+		Port:    tcpAddr.Port,
+		Done:    make(chan struct{}),
+		ln:      ln,
+		handler: handler,
+	}
+	t.Cleanup(func() {
+		close(s.Done)
+		ln.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// Close stops accepting connections, as a node going down.
+func (s *Server) Close() {
+	s.ln.Close()
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		reply := s.handler(args)
+		if reply == "" {
+			reply = Error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected command line %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// Status returns a simple string reply.
+func Status(s string) string {
+	return "+" + s + "\r\n"
+}
+
+// Error returns an error reply.
+func Error(s string) string {
+	return "-" + s + "\r\n"
+}
+
+// Int returns an integer reply.
+func Int(n int64) string {
+	return ":" + strconv.FormatInt(n, 10) + "\r\n"
+}
+
+// Bulk returns a bulk string reply.
+func Bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// Nil returns a null bulk string reply.
+func Nil() string {
+	return "$-1\r\n"
+}
+
+// Array returns an array reply of the encoded replies.
+func Array(replies ...string) string {
+	return fmt.Sprintf("*%d\r\n%s", len(replies), strings.Join(replies, ""))
+}
+
+// Map returns a RESP2 map reply, an array of alternating keys and values.
+func Map(kv ...string) string {
+	replies := make([]string, len(kv))
+	for i, s := range kv {
+		replies[i] = Bulk(s)
+	}
+	return Array(replies...)
+}
+
+// Info returns the INFO reply of sections given as "key:value" lines.
+func Info(lines ...string) string {
+	return Bulk(strings.Join(lines, "\r\n"))
+}
@@ -18,10 +18,16 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
@@ -36,39 +42,369 @@ import (
 	"github.com/prometheus/exporter-toolkit/web/kingpinflag"
 	"github.com/redis/go-redis/v9"
 	"github.com/xieyanke/redis_exporter/collector"
+	"github.com/xieyanke/redis_exporter/config"
 )
 
 var (
 	webConfig          = kingpinflag.AddFlags(kingpin.CommandLine, ":9121")
 	metricsPath        = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
-	addrs              = kingpin.Flag("redis.addrs", "Redis server addresses.").Default("localhost:6379").Strings()
-	passwd             = kingpin.Flag("redis.passwd", "Redis server password.").Default("").String()
+	configFile         = kingpin.Flag("config.file", "YAML file describing the targets to scrape, the redis flags are used when it is not set.").Default("").String()
+	addrs              = kingpin.Flag("redis.addrs", "Redis server addresses, as [user[:password]@]host:port, a unix socket path, a redis://, rediss:// or unix:// URI, or a dnssrv+<name> or dns+<host>:<port> DNS name.").Default("localhost:6379").Strings()
+	user               = kingpin.Flag("redis.user", "Redis ACL username.").Default("").String()
+	passwd             = kingpin.Flag("redis.passwd", "Redis server password.").Default("").Envar("REDIS_PASSWORD").String()
+	passwdFile         = kingpin.Flag("redis.passwd-file", "File containing the redis server password, overrides --redis.passwd.").Default("").String()
+	discoveryRefresh   = kingpin.Flag("redis.discovery-refresh-interval", "Maximum age of the discovered nodes used by the scrapes of a single node with the node parameter.").Default("30s").Duration()
+	dnsRefresh         = kingpin.Flag("redis.dns-refresh-interval", "Interval at which the dnssrv+ and dns+ addresses are resolved again.").Default("30s").Duration()
+	targetsFile        = kingpin.Flag("redis.targets-file", "JSON or YAML file listing more targets in the Prometheus file_sd format, reloaded when it changes. It replaces --redis.addrs.").Default("").String()
+	targetsFileRefresh = kingpin.Flag("redis.targets-file.refresh-interval", "Interval at which the targets file is checked for changes.").Default("30s").Duration()
+	credentialsFile    = kingpin.Flag("redis.credentials-file", "YAML file mapping target address patterns to usernames and passwords.").Default("").String()
 	db                 = kingpin.Flag("redis.db", "Redis db number.").Default("0").Int()
-	mode               = kingpin.Flag("redis.mode", "Redis server mode.").Default("standalone").String()
+	mode               = kingpin.Flag("redis.mode", "Redis server mode, auto detects it from the seed nodes.").Default("standalone").Enum("standalone", "cluster", "sentinel", "auto")
+	discoverReplicas   = kingpin.Flag("redis.discover-replicas", "Scrape the replicas listed in INFO replication of the standalone seed masters.").Bool()
+	recursiveReplicas  = kingpin.Flag("redis.discover-replicas-recursive", "Also scrape the replicas of the discovered replicas.").Bool()
 	clientName         = kingpin.Flag("redis.client-name", "Redis client name.").Default("redis_exporter").String()
+	tlsEnabled         = kingpin.Flag("redis.tls.enabled", "Connect to redis with TLS.").Bool()
+	certFile           = kingpin.Flag("redis.tls.cert-file", "Client certificate file.").Default("").String()
 	keyFile            = kingpin.Flag("redis.tls.key-file", "Client private key file.").Default("").String()
 	caFile             = kingpin.Flag("redis.tls.ca-file", "Client root ca file.").Default("").String()
+	serverName         = kingpin.Flag("redis.tls.server-name", "Server name used to verify the server certificate, defaults to the target host.").Default("").String()
+	minVersion         = kingpin.Flag("redis.tls.min-version", "Minimum TLS version.").Default("TLS12").Enum("TLS10", "TLS11", "TLS12", "TLS13")
 	insecureSkipVerify = kingpin.Flag("redis.tls.insecure-skip-verify", "Skip server certificate verification.").Bool()
+	labels             = kingpin.Flag("redis.labels", "Constant label added to every metric, as name=value. Can be repeated.").StringMap()
 	timeout            = kingpin.Flag("redis.timeout", "Redis connect timeout.").Default("1s").Duration()
+	scrapeTimeout      = kingpin.Flag("scrape.timeout", "Maximum duration of a scrape.").Default("10s").Duration()
+	scrapeInterval     = kingpin.Flag("scrape.interval", "Interval of the background scrapes serving /metrics from their last snapshot, 0 scrapes on every request.").Default("0s").Duration()
+	scrapeMinInterval  = kingpin.Flag("scrape.min-interval", "Minimum interval between two scrapes on requests, the last result is served in between.").Default("0s").Duration()
+	concurrency        = kingpin.Flag("scrape.concurrency", "Maximum number of (node, collector) scrapes running at once, 0 means no limit.").Default("32").Int()
+	nodeConcurrency    = kingpin.Flag("scrape.node-concurrency", "Maximum number of collectors scraping a single node at once, 0 means no limit.").Default("2").Int()
+	breakerMinBackoff  = kingpin.Flag("scrape.breaker.min-backoff", "Time an unreachable node is skipped after its first failed scrape, doubled on every failure. 0 disables the circuit breaker.").Default("5s").Duration()
+	breakerMaxBackoff  = kingpin.Flag("scrape.breaker.max-backoff", "Maximum time an unreachable node is skipped.").Default("5m").Duration()
+	staleDuration      = kingpin.Flag("scrape.stale-duration", "Time the last known metrics of an unreachable node are exported, 0 disables it.").Default("0s").Duration()
+	timeoutOffset      = kingpin.Flag("scrape.timeout-offset", "Offset subtracted from the timeout sent by Prometheus in X-Prometheus-Scrape-Timeout-Seconds.").Default("0.5s").Duration()
 )
 
+var (
+	clientListGroupByIP         = kingpin.Flag("collect.clients.group-by-ip", "Also group CLIENT LIST entries by client source ip.").Default("false").Bool()
+	clientListMaxGroups         = kingpin.Flag("collect.clients.max-groups", "Maximum number of client groups exported per node, the rest are folded into the 'other' group.").Default("50").Int()
+	configParams                = kingpin.Flag("collect.config.params", "Comma separated list of CONFIG GET parameters to export.").Default(strings.Join(collector.DefaultConfigParams, ",")).String()
+	configCommand               = kingpin.Flag("collect.config.command", "Name of the CONFIG command, for servers where it has been renamed.").Default("CONFIG").String()
+	bigKeysInterval             = kingpin.Flag("collect.bigkeys.interval", "Interval between two big key scans of the keyspace, negative disables the scans.").Default("1h").Duration()
+	bigKeysTopN                 = kingpin.Flag("collect.bigkeys.top-n", "Number of biggest keys kept per db and type, both by element count and by memory usage.").Default("10").Int()
+	bigKeysScanCount            = kingpin.Flag("collect.bigkeys.scan-count", "COUNT hint of each SCAN call of the big key scan.").Default("100").Int64()
+	bigKeysRateLimit            = kingpin.Flag("collect.bigkeys.rate-limit", "Maximum number of keys inspected per second on each redis node by the big key scan, negative disables the limit.").Default("1000").Int()
+	keyspacePrefixDelimiter     = kingpin.Flag("collect.keyspace.prefix.delimiter", "Delimiter splitting key names into namespaces.").Default(":").String()
+	keyspacePrefixDepth         = kingpin.Flag("collect.keyspace.prefix.depth", "Number of leading delimited parts of a key name used as its prefix.").Default("2").Int()
+	keyspacePrefixRegex         = kingpin.Flag("collect.keyspace.prefix.regex", "Regex extracting the prefix from a key name, the first capture group is used if any. Overrides the delimiter and depth.").Default("").String()
+	keyspacePrefixSampleSize    = kingpin.Flag("collect.keyspace.prefix.sample-size", "Number of keys sampled per db on each scrape.").Default("1000").Int()
+	keyspacePrefixSampleMethod  = kingpin.Flag("collect.keyspace.prefix.sample-method", "How keys are sampled, either randomkey or scan.").Default("randomkey").Enum("randomkey", "scan")
+	keyspacePrefixMemorySamples = kingpin.Flag("collect.keyspace.prefix.memory-samples", "SAMPLES argument of MEMORY USAGE for nested values.").Default("5").Int()
+	keyspacePrefixMaxPrefixes   = kingpin.Flag("collect.keyspace.prefix.max-prefixes", "Maximum number of prefixes exported per db, the rest are folded into the 'other' prefix.").Default("100").Int()
+	keysTTLSampleSize           = kingpin.Flag("collect.keys.ttl.sample-size", "Number of keys sampled per db on each scrape.").Default("1000").Int()
+	keysTTLSampleMethod         = kingpin.Flag("collect.keys.ttl.sample-method", "How keys are sampled, either randomkey or scan.").Default("randomkey").Enum("randomkey", "scan")
+	keysTTLBuckets              = bucketsFlag(kingpin.Flag("collect.keys.ttl.buckets", "Comma separated upper bounds in seconds of the key ttl histogram buckets.").Default("60,300,900,3600,21600,86400,604800"))
+	hotKeysSampleSize           = kingpin.Flag("collect.hotkeys.sample-size", "Number of keys sampled per db on each scrape.").Default("1000").Int()
+	hotKeysSampleMethod         = kingpin.Flag("collect.hotkeys.sample-method", "How keys are sampled, either randomkey or scan.").Default("randomkey").Enum("randomkey", "scan")
+	hotKeysTopN                 = kingpin.Flag("collect.hotkeys.top-n", "Number of hottest keys exported per db, and of hottest slots per node in cluster mode.").Default("10").Int()
+	pubSubPattern               = kingpin.Flag("collect.pubsub.pattern", "Pattern of the channels listed with PUBSUB CHANNELS and PUBSUB SHARDCHANNELS.").Default("*").String()
+	pubSubChannels              = kingpin.Flag("collect.pubsub.channels", "Comma separated list of channels always exported, even without subscribers.").Default("").String()
+	pubSubMaxChannels           = kingpin.Flag("collect.pubsub.max-channels", "Maximum number of listed channels exported per node, the ones with most subscribers are kept.").Default("100").Int()
+	aclLogEntries               = kingpin.Flag("collect.acl.log-entries", "Number of ACL LOG entries read on each scrape, should match acllog-max-len.").Default("128").Int()
+)
+
+// bucketsValue is a flag holding comma separated histogram bucket upper bounds.
+type bucketsValue []float64
+
+func (v *bucketsValue) Set(s string) error {
+	buckets, err := collector.ParseHistogramBuckets(s)
+	if err != nil {
+		return err
+	}
+	*v = buckets
+	return nil
+}
+
+func (v *bucketsValue) String() string {
+	items := make([]string, len(*v))
+	for i, f64 := range *v {
+		items[i] = strconv.FormatFloat(f64, 'g', -1, 64)
+	}
+	return strings.Join(items, ",")
+}
+
+func bucketsFlag(f *kingpin.FlagClause) *[]float64 {
+	v := &bucketsValue{}
+	f.SetValue(v)
+	return (*[]float64)(v)
+}
+
+// splitList splits a comma separated flag value, dropping the empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// tlsConfig is the TLS config of every redis connection, nil when TLS is disabled.
+var tlsConfig *tls.Config
+
 func init() {
 	prometheus.MustRegister(version.NewCollector("redis_exporter"))
+	prometheus.MustRegister(collector.AuthFailuresTotal)
+	prometheus.MustRegister(config.ReloadSuccess)
+	prometheus.MustRegister(config.ReloadSeconds)
+	prometheus.MustRegister(lastScrapeTimestamp)
+	prometheus.MustRegister(backgroundScrapeErrors)
+	prometheus.MustRegister(targetsCount)
+	prometheus.MustRegister(targetsFileLoadErrors)
+	prometheus.MustRegister(dnsDiscoveryAddresses)
+	prometheus.MustRegister(dnsDiscoveryErrors)
+}
+
+// scrapersTable are the scrapers with whether they are enabled by default. It
+// is created again by main once the flags of the scrapers are parsed.
+var scrapersTable = newScrapersTable()
+
+// newScrapersTable creates the scrapers with the settings of the flags.
+func newScrapersTable() map[collector.Scraper]bool {
+	return map[collector.Scraper]bool{
+		collector.NewInfoClientsScraper():      true,
+		collector.NewInfoCPUScraper():          true,
+		collector.NewInfoServerScraper():       true,
+		collector.NewInfoMemoryScraper():       true,
+		collector.NewInfoReplicationScraper():  true,
+		collector.NewInfoPersistenceScraper():  true,
+		collector.NewInfoStatsScraper():        true,
+		collector.NewInfoKeyspaceScraper():     true,
+		collector.NewInfoCommandStatsScraper(): true,
+		collector.NewClientListScraper(collector.ClientListSettings{
+			GroupByIP: *clientListGroupByIP,
+			MaxGroups: *clientListMaxGroups,
+		}): false,
+		collector.NewConfigScraper(collector.ConfigSettings{
+			Params:  splitList(*configParams),
+			Command: *configCommand,
+		}): false,
+		collector.NewMemoryStatsScraper(): false,
+		collector.NewBigKeysScraper(collector.BigKeysSettings{
+			Interval:  *bigKeysInterval,
+			TopN:      *bigKeysTopN,
+			ScanCount: *bigKeysScanCount,
+			RateLimit: *bigKeysRateLimit,
+		}): false,
+		collector.NewKeyspacePrefixScraper(collector.KeyspacePrefixSettings{
+			Delimiter:     *keyspacePrefixDelimiter,
+			Depth:         *keyspacePrefixDepth,
+			Regex:         *keyspacePrefixRegex,
+			SampleSize:    *keyspacePrefixSampleSize,
+			SampleMethod:  *keyspacePrefixSampleMethod,
+			MemorySamples: *keyspacePrefixMemorySamples,
+			MaxPrefixes:   *keyspacePrefixMaxPrefixes,
+		}): false,
+		collector.NewKeysTTLScraper(collector.KeysTTLSettings{
+			SampleSize:   *keysTTLSampleSize,
+			SampleMethod: *keysTTLSampleMethod,
+			Buckets:      *keysTTLBuckets,
+		}): false,
+		collector.NewHotKeysScraper(collector.HotKeysSettings{
+			SampleSize:    *hotKeysSampleSize,
+			SampleMethod:  *hotKeysSampleMethod,
+			TopN:          *hotKeysTopN,
+			ConfigCommand: *configCommand,
+		}): false,
+		collector.NewPubSubScraper(collector.PubSubSettings{
+			Pattern:     *pubSubPattern,
+			Channels:    splitList(*pubSubChannels),
+			MaxChannels: *pubSubMaxChannels,
+		}): false,
+		collector.NewACLScraper(collector.ACLSettings{
+			LogEntries: *aclLogEntries,
+		}): false,
+	}
 }
 
-var scrapersTable = map[collector.Scraper]bool{
-	collector.NewInfoClientsScraper():      true,
-	collector.NewInfoCPUScraper():          true,
-	collector.NewInfoServerScraper():       true,
-	collector.NewInfoMemoryScraper():       true,
-	collector.NewInfoReplicationScraper():  true,
-	collector.NewInfoPersistenceScraper():  true,
-	collector.NewInfoStatsScraper():        true,
-	collector.NewInfoKeyspaceScraper():     true,
-	collector.NewInfoCommandStatsScraper(): true,
+// clusterInfoScraper is enabled on the targets in cluster mode.
+var clusterInfoScraper = collector.NewClusterInfoScraper()
+
+// scrapersByName are the scrapers which can be listed in the config file.
+var scrapersByName = newScrapersByName()
+
+func newScrapersByName() map[string]collector.Scraper {
+	m := map[string]collector.Scraper{clusterInfoScraper.Name(): clusterInfoScraper}
+	for scraper := range scrapersTable {
+		m[scraper.Name()] = scraper
+	}
+	return m
 }
 
-func newHandler(scrapers []collector.Scraper, logger log.Logger) http.HandlerFunc {
+// targets are the targets currently scraped.
+var targets = &targetSet{}
+
+// scheduler bounds the concurrency of the scrapes of all the targets.
+var scheduler *collector.Scheduler
+
+// breaker skips the unreachable nodes of all the targets, nil when disabled.
+var breaker *collector.Breaker
+
+// dnsResolver resolves the dnssrv+ and dns+ addresses of the targets.
+var dnsResolver *dnsDiscovery
+
+// checkACL dry-runs the commands of the scrapers of t against the ACL of the
+// exporter user, so missing permissions are reported when the targets are loaded.
+func checkACL(t *target, logger log.Logger) {
+	// The seeds of a sentinel target are sentinels, which aren't scraped.
+	if t.mode == "sentinel" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	addrs := t.seedAddrs(ctx)
+	if len(addrs) == 0 {
+		return
+	}
+	seed, err := t.newRedisOptions(addrs[0])
+	if err != nil {
+		return
+	}
+	rdb := collector.NewClient(seed)
+	defer rdb.Close()
+
+	// The scrapers of an auto target depend on the mode of its nodes.
+	mode := t.mode
+	if mode == "auto" {
+		var err error
+		mode, _, err = collector.GetRedisMode(ctx, rdb)
+		if err != nil {
+			level.Warn(logger).Log("msg", "Unable to detect the redis mode to check the ACL permissions of the exporter user", "addr", seed.Addr, "err", err)
+			return
+		}
+		if mode == "sentinel" {
+			return
+		}
+	}
+
+	username := seed.Username
+	if username == "" {
+		username = "default"
+	}
+	collector.CheckScrapersACL(ctx, rdb, username, t.scrapersFor(mode), log.With(logger, "addr", seed.Addr))
+}
+
+// loadTargets returns the targets of the config file, or the target of the
+// flags when there is no config file.
+func loadTargets(scrapers []collector.Scraper, logger log.Logger) ([]*target, error) {
+	if err := config.ValidateLabels(*labels); err != nil {
+		return nil, err
+	}
+
+	if *configFile == "" {
+		if *targetsFile != "" {
+			return nil, nil
+		}
+		t := newFlagTarget(scrapers, logger)
+		t.labels = mergeLabels(*labels)
+		if err := t.validateAddrs(); err != nil {
+			return nil, err
+		}
+		return []*target{t}, nil
+	}
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var ts []*target
+	for _, c := range cfg.Targets {
+		t, err := newConfigTarget(c, scrapers, logger)
+		if err != nil {
+			return nil, fmt.Errorf("target %q: %w", c.Name, err)
+		}
+		if err := t.validateAddrs(); err != nil {
+			return nil, fmt.Errorf("target %q: %w", c.Name, err)
+		}
+		t.labels = mergeLabels(*labels, cfg.Labels, c.Labels, map[string]string{"target": t.name})
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// reloadTargets replaces the current targets, which are kept if the new ones
+// can't be loaded.
+func reloadTargets(scrapers []collector.Scraper, logger log.Logger) error {
+	ts, err := loadTargets(scrapers, logger)
+	if err == nil {
+		_, fileTargets := targets.names()
+		for _, t := range ts {
+			if fileTargets[t.name] {
+				err = fmt.Errorf("target %q is already in the targets file", t.name)
+				break
+			}
+		}
+	}
+	if err != nil {
+		config.ReloadSuccess.Set(0)
+		return err
+	}
+
+	targets.set(ts)
+	config.ReloadSuccess.Set(1)
+	config.ReloadSeconds.SetToCurrentTime()
+
+	for _, t := range ts {
+		checkACL(t, t.logger)
+	}
+	return nil
+}
+
+func newReloadHandler(scrapers []collector.Scraper, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "This endpoint requires a POST request.", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := reloadTargets(scrapers, logger); err != nil {
+			level.Error(logger).Log("msg", "Error reloading config", "err", err)
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+			return
+		}
+		level.Info(logger).Log("msg", "Reloaded config file", "file", *configFile)
+	}
+}
+
+// mergeLabels returns the union of labels, later ones overriding earlier ones.
+func mergeLabels(labels ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, l := range labels {
+		for k, v := range l {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// labelsFromParams returns the labels given as `label_<name>=<value>` query
+// parameters, set from `__param_label_<name>` in the scrape config.
+func labelsFromParams(params url.Values) (map[string]string, error) {
+	labels := map[string]string{}
+	for param := range params {
+		if name := strings.TrimPrefix(param, "label_"); name != param {
+			labels[name] = params.Get(param)
+		}
+	}
+	if err := config.ValidateLabels(labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+func newHandler(cache *scrapeCache, logger log.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		var timeoutSeconds float64
@@ -79,51 +415,49 @@ func newHandler(scrapers []collector.Scraper, logger log.Logger) http.HandlerFun
 			}
 		}
 
+		// Leave some time to send the response before Prometheus gives up.
+		requestTimeout := *scrapeTimeout
 		if timeoutSeconds > 0 {
-			*timeout = time.Duration(timeoutSeconds * float64(time.Second))
+			headerTimeout := time.Duration(timeoutSeconds * float64(time.Second))
+			if headerTimeout > *timeoutOffset {
+				headerTimeout -= *timeoutOffset
+			}
+			if headerTimeout < requestTimeout {
+				requestTimeout = headerTimeout
+			}
 		}
 
-		ctx := r.Context()
-
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, *timeout)
-		defer cancel()
-
-		r = r.WithContext(ctx)
-		var initCli *redis.Client
-		for _, addr := range *addrs {
-			initCli = redis.NewClient(&redis.Options{
-				Addr:     addr,
-				Password: *passwd,
-			})
-
-			err = initCli.Ping(ctx).Err()
-			if err == nil {
-				break
-			} else {
-				level.Error(logger).Log("msg", fmt.Sprintf("%s can't connect", addr), "err", err)
-			}
+		name := r.URL.Query().Get("target")
+		scraped := selectTargets(name)
+		if name != "" && len(scraped) == 0 {
+			http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+			return
 		}
-		defer initCli.Close()
 
-		var allAddrs []string
-		switch *mode {
-		case "cluster":
-			allAddrs, _ = collector.GetRedisClusterNodes(ctx, initCli)
-		default:
-			allAddrs = *addrs
+		node := r.URL.Query().Get("node")
+		paramLabels, err := labelsFromParams(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		var opts []*redis.Options
-		for _, addr := range allAddrs {
-			opts = append(opts, &redis.Options{Addr: addr, Password: *passwd})
+		var snap *snapshot
+		if *scrapeInterval > 0 {
+			snap = cache.latest()
+			if snap == nil {
+				http.Error(w, "no scrape completed yet", http.StatusServiceUnavailable)
+				return
+			}
+			snap = snap.filter(name, node, paramLabels)
+		} else {
+			snap = cache.scrape(scrapeKey(name, node, paramLabels), requestTimeout, func(ctx context.Context) *snapshot {
+				return gatherTargets(ctx, scraped, node, paramLabels)
+			})
 		}
 
-		registry := prometheus.NewRegistry()
-		registry.MustRegister(collector.New(ctx, opts, scrapers, logger))
 		gatherers := prometheus.Gatherers{
 			prometheus.DefaultGatherer,
-			registry,
+			snap,
 		}
 
 		h := promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
@@ -133,7 +467,7 @@ func newHandler(scrapers []collector.Scraper, logger log.Logger) http.HandlerFun
 
 func main() {
 	// Generate ON/OFF flags for all scrapers.
-	scraperFlags := map[collector.Scraper]*bool{}
+	scraperFlags := map[string]*bool{}
 	for scraper, enabledByDefault := range scrapersTable {
 		defaultOn := "false"
 		if enabledByDefault {
@@ -145,7 +479,7 @@ func main() {
 			scraper.Help(),
 		).Default(defaultOn).Bool()
 
-		scraperFlags[scraper] = f
+		scraperFlags[scraper.Name()] = f
 	}
 
 	promlogconfig := &promlog.Config{}
@@ -157,22 +491,88 @@ func main() {
 
 	logger := promlog.New(promlogconfig)
 
+	scrapersTable = newScrapersTable()
+	scrapersByName = newScrapersByName()
+
+	scheduler = collector.NewScheduler(*concurrency, *nodeConcurrency)
+	if *breakerMinBackoff > 0 {
+		breaker = collector.NewBreaker(*breakerMinBackoff, *breakerMaxBackoff, *staleDuration)
+	}
+	creds = newCredentialsStore(*passwdFile, *credentialsFile, logger)
+	dnsResolver = newDNSDiscovery(net.DefaultResolver, *dnsRefresh, logger)
+
+	if *tlsEnabled || *certFile != "" || *caFile != "" {
+		var err error
+		tlsConfig, err = newTLSConfig(*certFile, *keyFile, *caFile, *serverName, *minVersion, *insecureSkipVerify)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error loading TLS config", "err", err)
+			os.Exit(1)
+		}
+	}
+
 	enabledScrapers := []collector.Scraper{}
 
-	for scraper, enabled := range scraperFlags {
-		if *enabled {
+	for name, enabled := range scraperFlags {
+		if scraper := scrapersByName[name]; *enabled {
 			level.Info(logger).Log("msg", "Scraper enabled", "scraper", scraper.Name())
 			enabledScrapers = append(enabledScrapers, scraper)
 		}
 	}
 
-	if *mode == "cluster" {
-		scraper := collector.NewClusterInfoScraper()
-		enabledScrapers = append(enabledScrapers, scraper)
+	if err := reloadTargets(enabledScrapers, logger); err != nil {
+		level.Error(logger).Log("msg", "Error loading config", "err", err)
+		os.Exit(1)
 	}
 
-	handlerFunc := newHandler(enabledScrapers, logger)
+	if *targetsFile != "" {
+		f := &watchedFile{path: *targetsFile}
+		if err := reloadTargetsFile(f, enabledScrapers, logger); err != nil {
+			targetsFileLoadErrors.Inc()
+			level.Error(logger).Log("msg", "Error loading targets file", "file", f.path, "err", err)
+		}
+		go watchTargetsFile(context.Background(), f, *targetsFileRefresh, enabledScrapers, logger)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloadTargets(enabledScrapers, logger); err != nil {
+				level.Error(logger).Log("msg", "Error reloading config", "err", err)
+				continue
+			}
+			level.Info(logger).Log("msg", "Reloaded config file", "file", *configFile)
+		}
+	}()
+
+	// Background scrapers run on the nodes of every target enabling them.
+	for _, scraper := range scrapersByName {
+		if bs, ok := scraper.(collector.BackgroundScraper); ok {
+			nodes := func(ctx context.Context) []*redis.Options {
+				var opts []*redis.Options
+				for _, t := range targets.get() {
+					if t.hasScraper(bs) {
+						nctx, cancel := context.WithTimeout(ctx, *timeout)
+						opts = append(opts, newRedisNodes(nctx, t, t.logger).opts...)
+						cancel()
+					}
+				}
+				return opts
+			}
+			go bs.Start(context.Background(), nodes, log.With(logger, "scraper", bs.Name()))
+		}
+	}
+
+	cache := newScrapeCache(*scrapeMinInterval)
+	if *scrapeInterval > 0 {
+		go cache.runBackground(context.Background(), *scrapeInterval, logger)
+	}
+
+	handlerFunc := newHandler(cache, logger)
 	http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handlerFunc))
+	http.Handle("/-/reload", newReloadHandler(enabledScrapers, logger))
+	http.Handle("/status", newStatusHandler(breaker, logger))
+	http.Handle("/sd", newSDHandler(logger))
 
 	if *metricsPath != "/" && *metricsPath != "" {
 		landingConfig := web.LandingConfig{
@@ -184,6 +584,14 @@ func main() {
 					Address: *metricsPath,
 					Text:    "Metrics",
 				},
+				{
+					Address: "/status",
+					Text:    "Status",
+				},
+				{
+					Address: "/sd",
+					Text:    "Service discovery",
+				},
 			},
 		}
 
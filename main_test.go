@@ -0,0 +1,73 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/xieyanke/redis_exporter/collector"
+)
+
+// TestCheckACL checks that the commands dry-run are those of the scrapers for
+// the mode of the nodes, detected from the seed in auto mode.
+func TestCheckACL(t *testing.T) {
+	old := *timeout
+	*timeout = 5 * time.Second
+	defer func() { *timeout = old }()
+
+	tests := []struct {
+		name       string
+		mode       string
+		targetMode string
+		want       []string
+	}{
+		{name: "standalone", mode: "standalone", targetMode: "standalone", want: []string{"client list"}},
+		{name: "cluster", mode: "cluster", targetMode: "cluster", want: []string{"client list", "cluster info"}},
+		{name: "auto standalone", mode: "standalone", targetMode: "auto", want: []string{"client list"}},
+		{name: "auto cluster", mode: "cluster", targetMode: "auto", want: []string{"client list", "cluster info"}},
+		{name: "auto sentinel", mode: "sentinel", targetMode: "auto"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands := make(chan string, 100)
+			seed := newFakeModeNode(t, tt.mode, commands)
+
+			checkACL(&target{
+				addrs:    []string{seed.Addr},
+				mode:     tt.targetMode,
+				scrapers: []collector.Scraper{collector.NewClientListScraper(collector.ClientListSettings{})},
+				logger:   log.NewNopLogger(),
+			}, log.NewNopLogger())
+
+			var got []string
+			for len(commands) > 0 {
+				command := <-commands
+				if dryRun := strings.TrimPrefix(command, "acl dryrun default "); dryRun != command {
+					got = append(got, dryRun)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dry-run commands = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/xieyanke/redis_exporter/collector"
+	"golang.org/x/sync/singleflight"
+)
+
+var lastScrapeTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "redis_exporter",
+	Name:      "last_scrape_timestamp_seconds",
+	Help:      "Timestamp of the end of the last scrape of the redis targets.",
+})
+
+var backgroundScrapeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "redis_exporter",
+	Name:      "background_scrape_errors_total",
+	Help:      "Number of background scrapes which failed to gather the metrics.",
+})
+
+// snapshot is the result of a scrape of some targets.
+type snapshot struct {
+	families []*dto.MetricFamily
+	err      error
+	time     time.Time
+}
+
+// Gather implements prometheus.Gatherer.
+func (s *snapshot) Gather() ([]*dto.MetricFamily, error) {
+	return s.families, s.err
+}
+
+// filter returns the metrics of target, all of them if it is empty, with the
+// labels added. When node is set, only the series a scrape of that node alone
+// returns are kept, see ofNode. The families of the snapshot are left
+// untouched.
+func (s *snapshot) filter(target, node string, labels map[string]string) *snapshot {
+	if target == "" && node == "" && len(labels) == 0 {
+		return s
+	}
+
+	filtered := &snapshot{err: s.err, time: s.time}
+	for _, mf := range s.families {
+		var metrics []*dto.Metric
+		for _, m := range mf.GetMetric() {
+			if target != "" && labelValue(m, "target") != target {
+				continue
+			}
+			if node != "" && !ofNode(m, node) {
+				continue
+			}
+			metrics = append(metrics, withLabels(m, labels))
+		}
+		if len(metrics) > 0 {
+			filtered.families = append(filtered.families, &dto.MetricFamily{
+				Name:   mf.Name,
+				Help:   mf.Help,
+				Type:   mf.Type,
+				Metric: metrics,
+			})
+		}
+	}
+	return filtered
+}
+
+// ofNode reports whether m is a series of a scrape of node alone: the metrics
+// of the node, the replication links it is part of, and the metrics of the
+// whole scrape.
+func ofNode(m *dto.Metric, node string) bool {
+	labels := make(map[string]string, len(m.GetLabel()))
+	for _, l := range m.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+
+	if addr, ok := labels["addr"]; ok {
+		return addr == node
+	}
+	master, hasMaster := labels["master"]
+	replica, hasReplica := labels["replica"]
+	if hasMaster || hasReplica {
+		return master == node || replica == node
+	}
+	return true
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// withLabels returns a copy of m with labels added, or overridden.
+func withLabels(m *dto.Metric, labels map[string]string) *dto.Metric {
+	if len(labels) == 0 {
+		return m
+	}
+
+	var pairs []*dto.LabelPair
+	for _, l := range m.GetLabel() {
+		if _, ok := labels[l.GetName()]; !ok {
+			pairs = append(pairs, l)
+		}
+	}
+	for name, value := range labels {
+		name, value := name, value
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+
+	return &dto.Metric{
+		Label:       pairs,
+		Gauge:       m.Gauge,
+		Counter:     m.Counter,
+		Summary:     m.Summary,
+		Untyped:     m.Untyped,
+		Histogram:   m.Histogram,
+		TimestampMs: m.TimestampMs,
+	}
+}
+
+// selectTargets returns the target named name, or all the targets if it is empty.
+func selectTargets(name string) []*target {
+	if name == "" {
+		return targets.get()
+	}
+
+	var selected []*target
+	for _, t := range targets.get() {
+		if t.name == name {
+			selected = append(selected, t)
+		}
+	}
+	return selected
+}
+
+// gatherTargets scrapes the targets and returns the gathered metrics. Only the
+// node whose address is node is scraped unless it is empty, it is an error if
+// no target has it.
+func gatherTargets(ctx context.Context, scraped []*target, node string, paramLabels map[string]string) *snapshot {
+	// Every target gets the same label names, so the metrics they share
+	// have consistent descriptors.
+	var targetLabels []map[string]string
+	names := map[string]bool{}
+	for _, t := range scraped {
+		l := mergeLabels(t.labels, paramLabels)
+		for name := range l {
+			names[name] = true
+		}
+		targetLabels = append(targetLabels, l)
+	}
+
+	registry := prometheus.NewRegistry()
+	found := false
+	for i, t := range scraped {
+		var nodes *redisNodes
+		if node == "" {
+			nodes = newRedisNodes(ctx, t, t.logger)
+		} else {
+			// The scrapes of single nodes, one per node listed by /sd, share
+			// the discovery of their target.
+			nodes = cachedRedisNodes(ctx, t, *discoveryRefresh, t.logger).filter(node)
+			if len(nodes.opts) == 0 {
+				continue
+			}
+		}
+		found = true
+
+		labels := prometheus.Labels{}
+		for name := range names {
+			labels[name] = targetLabels[i][name]
+		}
+
+		e := collector.New(nodes.opts, t.scrapersFor(nodes.mode),
+			collector.WithLogger(t.logger),
+			collector.WithLabels(labels),
+			collector.WithKeyCheckSettings(t.keyChecks),
+			collector.WithTimeout(*scrapeTimeout),
+			collector.WithScheduler(scheduler),
+			collector.WithBreaker(breaker),
+		)
+		if err := e.WithContext(ctx).Register(registry); err != nil {
+			level.Error(t.logger).Log("msg", "Error registering exporter", "err", err)
+		}
+		if len(nodes.links) > 0 {
+			if err := prometheus.WrapRegistererWith(labels, registry).Register(nodes); err != nil {
+				level.Error(t.logger).Log("msg", "Error registering replication topology", "err", err)
+			}
+		}
+	}
+
+	if !found && node != "" {
+		return &snapshot{err: fmt.Errorf("unknown node %q", node), time: time.Now()}
+	}
+
+	families, err := registry.Gather()
+	lastScrapeTimestamp.SetToCurrentTime()
+
+	return &snapshot{families: families, err: err, time: time.Now()}
+}
+
+// scrapeKey identifies the scrapes returning the same metrics.
+func scrapeKey(target, node string, paramLabels map[string]string) string {
+	v := url.Values{}
+	v.Set("target", target)
+	v.Set("node", node)
+	for name, value := range paramLabels {
+		v.Set(name, value)
+	}
+	return v.Encode()
+}
+
+// scrapeCache shares the scrapes between the requests. Overlapping requests
+// share a single scrape, and a scrape younger than minInterval is served again.
+// In background mode it holds the snapshot of the last background scrape.
+type scrapeCache struct {
+	minInterval time.Duration
+	group       singleflight.Group
+
+	mu        sync.Mutex
+	snapshots map[string]*snapshot
+	last      *snapshot
+}
+
+func newScrapeCache(minInterval time.Duration) *scrapeCache {
+	return &scrapeCache{
+		minInterval: minInterval,
+		snapshots:   make(map[string]*snapshot),
+	}
+}
+
+// latest returns the snapshot of the last successful background scrape, nil if
+// none has completed yet.
+func (c *scrapeCache) latest() *snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// scrape returns the result of gather for key, run at most once at a time and
+// at most once per minInterval. The scrape is bounded by timeout rather than by
+// the request, since other requests may be waiting for it.
+func (c *scrapeCache) scrape(key string, timeout time.Duration, gather func(context.Context) *snapshot) *snapshot {
+	if c.minInterval > 0 {
+		c.mu.Lock()
+		snap, ok := c.snapshots[key]
+		c.mu.Unlock()
+		if ok && time.Since(snap.time) < c.minInterval {
+			return snap
+		}
+	}
+
+	v, _, _ := c.group.Do(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		snap := gather(ctx)
+		if c.minInterval > 0 {
+			c.mu.Lock()
+			c.store(key, snap)
+			c.mu.Unlock()
+		}
+		return snap, nil
+	})
+	return v.(*snapshot)
+}
+
+// maxCachedScrapes bounds the number of scrapes kept for minInterval, their
+// keys include the label parameters of the requests.
+const maxCachedScrapes = 1024
+
+// store keeps snap for key. The expired scrapes are dropped, then the oldest
+// ones while there are more than maxCachedScrapes. c.mu must be held.
+func (c *scrapeCache) store(key string, snap *snapshot) {
+	for k, s := range c.snapshots {
+		if time.Since(s.time) >= c.minInterval {
+			delete(c.snapshots, k)
+		}
+	}
+	for len(c.snapshots) >= maxCachedScrapes {
+		var oldest string
+		for k, s := range c.snapshots {
+			if oldest == "" || s.time.Before(c.snapshots[oldest].time) {
+				oldest = k
+			}
+		}
+		delete(c.snapshots, oldest)
+	}
+	c.snapshots[key] = snap
+}
+
+// update serves snap from now on, unless it failed: the last good scrape is
+// served until the next one then.
+func (c *scrapeCache) update(snap *snapshot, logger log.Logger) {
+	if snap.err != nil {
+		backgroundScrapeErrors.Inc()
+		level.Error(logger).Log("msg", "Error gathering metrics, serving the last scrape", "err", snap.err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = snap
+}
+
+// runBackground scrapes all the targets every interval until ctx is done.
+func (c *scrapeCache) runBackground(ctx context.Context, interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sctx, cancel := context.WithTimeout(ctx, *scrapeTimeout)
+		snap := gatherTargets(sctx, targets.get(), "", nil)
+		cancel()
+		c.update(snap, logger)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
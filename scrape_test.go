@@ -0,0 +1,125 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newFamily(name string, labelSets ...map[string]string) *dto.MetricFamily {
+	mf := &dto.MetricFamily{Name: &name, Type: dto.MetricType_GAUGE.Enum()}
+	for _, labels := range labelSets {
+		m := &dto.Metric{Gauge: &dto.Gauge{Value: new(float64)}}
+		for n, v := range labels {
+			n, v := n, v
+			m.Label = append(m.Label, &dto.LabelPair{Name: &n, Value: &v})
+		}
+		mf.Metric = append(mf.Metric, m)
+	}
+	return mf
+}
+
+func seriesOf(s *snapshot) []string {
+	var series []string
+	for _, mf := range s.families {
+		for _, m := range mf.GetMetric() {
+			var pairs []string
+			for _, l := range m.GetLabel() {
+				pairs = append(pairs, l.GetName()+"="+l.GetValue())
+			}
+			sort.Strings(pairs)
+			series = append(series, mf.GetName()+"{"+strings.Join(pairs, ",")+"}")
+		}
+	}
+	sort.Strings(series)
+	return series
+}
+
+// TestSnapshotFilterNode checks that filtering a background snapshot on a node
+// keeps the series a scrape of that node alone returns.
+func TestSnapshotFilterNode(t *testing.T) {
+	snap := &snapshot{families: []*dto.MetricFamily{
+		newFamily("redis_up", map[string]string{"target": "cache"}),
+		newFamily("redis_exporter_scrape_success", map[string]string{"target": "cache", "collector": "collect.info.server"}),
+		newFamily("redis_server_uptime_in_seconds",
+			map[string]string{"target": "cache", "addr": "10.0.0.1:6379"},
+			map[string]string{"target": "cache", "addr": "10.0.0.2:6379"},
+			map[string]string{"target": "other", "addr": "10.0.0.1:6379"},
+		),
+		newFamily("redis_replication_topology_info",
+			map[string]string{"target": "cache", "master": "10.0.0.1:6379", "replica": "10.0.0.2:6379"},
+			map[string]string{"target": "cache", "master": "10.0.0.2:6379", "replica": "10.0.0.3:6379"},
+		),
+	}}
+
+	got := seriesOf(snap.filter("cache", "10.0.0.2:6379", map[string]string{"env": "prod"}))
+	want := []string{
+		"redis_exporter_scrape_success{collector=collect.info.server,env=prod,target=cache}",
+		"redis_replication_topology_info{env=prod,master=10.0.0.1:6379,replica=10.0.0.2:6379,target=cache}",
+		"redis_replication_topology_info{env=prod,master=10.0.0.2:6379,replica=10.0.0.3:6379,target=cache}",
+		"redis_server_uptime_in_seconds{addr=10.0.0.2:6379,env=prod,target=cache}",
+		"redis_up{env=prod,target=cache}",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got series\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestScrapeCacheBound checks that the scrapes kept for minInterval are
+// bounded, however many label parameters the requests use.
+func TestScrapeCacheBound(t *testing.T) {
+	c := newScrapeCache(time.Hour)
+	gather := func(context.Context) *snapshot { return &snapshot{time: time.Now()} }
+
+	for i := 0; i < maxCachedScrapes+10; i++ {
+		c.scrape(scrapeKey("", "", map[string]string{"label_id": fmt.Sprint(i)}), time.Second, gather)
+	}
+	if n := len(c.snapshots); n != maxCachedScrapes {
+		t.Errorf("got %d cached scrapes, want %d", n, maxCachedScrapes)
+	}
+	// The most recent scrape is kept.
+	if _, ok := c.snapshots[scrapeKey("", "", map[string]string{"label_id": fmt.Sprint(maxCachedScrapes + 9)})]; !ok {
+		t.Error("the last scrape was evicted")
+	}
+}
+
+// TestScrapeCacheUpdate checks that a failed background scrape doesn't replace
+// the last good one.
+func TestScrapeCacheUpdate(t *testing.T) {
+	c := newScrapeCache(0)
+	good := &snapshot{time: time.Now()}
+
+	c.update(&snapshot{err: errors.New("collected metric was collected before")}, log.NewNopLogger())
+	if c.latest() != nil {
+		t.Error("a failed scrape is served")
+	}
+	c.update(good, log.NewNopLogger())
+	c.update(&snapshot{err: errors.New("collected metric was collected before")}, log.NewNopLogger())
+	if c.latest() != good {
+		t.Error("the last good scrape isn't served after a failed one")
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// targetGroup is a target group of the Prometheus HTTP service discovery.
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// newSDHandler serves the discovered nodes of the targets in the Prometheus
+// http_sd format, one group per node. The node and its target are passed as
+// the node and target parameters of the scrape, so every node is scraped on
+// its own through the metrics endpoint.
+func newSDHandler(logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if *scrapeTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *scrapeTimeout)
+			defer cancel()
+		}
+
+		groups := []targetGroup{}
+		for _, t := range targets.get() {
+			nodes := newRedisNodes(ctx, t, t.logger)
+			for i, opt := range nodes.opts {
+				node := nodes.nodes[i]
+				labels := map[string]string{
+					"__param_node":           opt.Addr,
+					"__meta_redis_mode":      nodes.mode,
+					"__meta_redis_role":      node.Role,
+					"__meta_redis_shard":     node.Shard,
+					"__meta_redis_master_id": node.MasterID,
+				}
+				if t.name != "" {
+					labels["__param_target"] = t.name
+					labels["__meta_redis_target"] = t.name
+				}
+				groups = append(groups, targetGroup{Targets: []string{opt.Addr}, Labels: labels})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(groups); err != nil {
+			level.Error(logger).Log("msg", "Error writing service discovery response", "err", err)
+		}
+	}
+}
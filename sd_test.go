@@ -0,0 +1,102 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+// useTargets replaces the current targets for the duration of the test.
+func useTargets(t *testing.T, ts ...*target) {
+	old := targets
+	targets = &targetSet{targets: ts}
+	t.Cleanup(func() { targets = old })
+}
+
+func TestSDHandler(t *testing.T) {
+	replica := newFakeNode(t, "slave")
+	master := newFakeNode(t, "master", replica)
+
+	useTargets(t, &target{
+		name:             "cache",
+		addrs:            []string{master.Addr},
+		mode:             "standalone",
+		discoverReplicas: true,
+		logger:           log.NewNopLogger(),
+	})
+
+	rec := httptest.NewRecorder()
+	newSDHandler(log.NewNopLogger())(rec, httptest.NewRequest(http.MethodGet, "/sd", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type = %q, want application/json", ct)
+	}
+
+	var got []targetGroup
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []targetGroup{
+		{
+			Targets: []string{master.Addr},
+			Labels: map[string]string{
+				"__param_target":         "cache",
+				"__param_node":           master.Addr,
+				"__meta_redis_target":    "cache",
+				"__meta_redis_mode":      "standalone",
+				"__meta_redis_role":      "master",
+				"__meta_redis_shard":     master.Addr,
+				"__meta_redis_master_id": master.Addr,
+			},
+		},
+		{
+			Targets: []string{replica.Addr},
+			Labels: map[string]string{
+				"__param_target":         "cache",
+				"__param_node":           replica.Addr,
+				"__meta_redis_target":    "cache",
+				"__meta_redis_mode":      "standalone",
+				"__meta_redis_role":      "slave",
+				"__meta_redis_shard":     master.Addr,
+				"__meta_redis_master_id": master.Addr,
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got groups\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestSDHandlerNoTarget(t *testing.T) {
+	useTargets(t)
+
+	rec := httptest.NewRecorder()
+	newSDHandler(log.NewNopLogger())(rec, httptest.NewRequest(http.MethodGet, "/sd", nil))
+
+	if body := rec.Body.String(); body != "[]\n" {
+		t.Errorf("body = %q, want an empty list", body)
+	}
+}
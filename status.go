@@ -0,0 +1,67 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/xieyanke/redis_exporter/collector"
+)
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Redis Exporter Status</title></head>
+<body>
+<h1>Redis Exporter Status</h1>
+<h2>Nodes</h2>
+{{if .}}
+<table border="1" cellpadding="4">
+<tr><th>Address</th><th>Breaker</th><th>Consecutive failures</th><th>Next probe</th><th>Last success</th></tr>
+{{range .}}
+<tr>
+<td>{{.Addr}}</td>
+<td>{{if .Open}}open{{else}}closed{{end}}</td>
+<td>{{.Failures}}</td>
+<td>{{if .Open}}{{.NextProbe.Format "2006-01-02T15:04:05Z07:00"}}{{end}}</td>
+<td>{{if not .LastSuccess.IsZero}}{{.LastSuccess.Format "2006-01-02T15:04:05Z07:00"}}{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No node scraped yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// newStatusHandler serves the circuit breaker state of the nodes.
+func newStatusHandler(breaker *collector.Breaker, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var status []collector.NodeStatus
+		if breaker != nil {
+			status = breaker.Status()
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusTemplate.Execute(w, status); err != nil {
+			level.Error(logger).Log("msg", "Error rendering status page", "err", err)
+		}
+	}
+}
@@ -0,0 +1,532 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/xieyanke/redis_exporter/collector"
+	"github.com/xieyanke/redis_exporter/config"
+)
+
+// creds resolves the credentials from the password and credentials files.
+var creds *credentialsStore
+
+// target is a group of redis nodes scraped with the same settings, built from
+// the flags or from an entry of the config file.
+type target struct {
+	name       string
+	addrs      []string
+	mode       string
+	username   string
+	password   string
+	passwdFile *watchedFile
+	tlsConfig  *tls.Config
+	scrapers   []collector.Scraper
+	labels     map[string]string
+	keyChecks  *collector.KeyCheckSettings
+	// discoverReplicas adds the replicas of the standalone masters to the
+	// nodes, recursiveReplicas the replicas of those replicas too.
+	discoverReplicas  bool
+	recursiveReplicas bool
+	logger            log.Logger
+
+	// nodes are the nodes found by the last discovery which found some, at
+	// discovered.
+	mu         sync.Mutex
+	nodes      *redisNodes
+	discovered time.Time
+}
+
+// newFlagTarget returns the single target configured by the flags.
+func newFlagTarget(scrapers []collector.Scraper, logger log.Logger) *target {
+	t := &target{
+		addrs:     *addrs,
+		mode:      *mode,
+		tlsConfig: tlsConfig,
+		scrapers:  scrapers,
+		keyChecks: &collector.KeyCheckSettings{},
+		logger:    logger,
+
+		discoverReplicas:  *discoverReplicas,
+		recursiveReplicas: *recursiveReplicas,
+	}
+	return t
+}
+
+// newConfigTarget returns the target of a config file entry. The scrapers
+// enabled by the flags are used unless the entry lists its own.
+func newConfigTarget(cfg *config.Target, scrapers []collector.Scraper, logger log.Logger) (*target, error) {
+	t := &target{
+		name:      cfg.Name,
+		addrs:     cfg.Addrs,
+		mode:      cfg.Mode,
+		username:  cfg.Username,
+		password:  cfg.Password,
+		tlsConfig: tlsConfig,
+		scrapers:  scrapers,
+		labels:    cfg.Labels,
+		keyChecks: &collector.KeyCheckSettings{},
+		logger:    log.With(logger, "target", cfg.Name),
+
+		discoverReplicas:  *discoverReplicas,
+		recursiveReplicas: *recursiveReplicas,
+	}
+	if cfg.DiscoverReplicas != nil {
+		t.discoverReplicas = *cfg.DiscoverReplicas
+	}
+	if cfg.RecursiveReplicas != nil {
+		t.recursiveReplicas = *cfg.RecursiveReplicas
+	}
+
+	if cfg.PasswordFile != "" {
+		t.passwdFile = &watchedFile{path: cfg.PasswordFile}
+		if _, _, err := t.passwdFile.read(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.TLS != nil {
+		var err error
+		t.tlsConfig, err = newTLSConfig(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.CAFile, cfg.TLS.ServerName, cfg.TLS.MinVersion, cfg.TLS.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cfg.Scrapers) > 0 {
+		t.scrapers = nil
+		for _, name := range cfg.Scrapers {
+			scraper, ok := scrapersByName[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown scraper %q", name)
+			}
+			t.scrapers = append(t.scrapers, scraper)
+		}
+	}
+
+	if kc := cfg.KeyChecks; kc != nil {
+		t.keyChecks = &collector.KeyCheckSettings{
+			SampleSize:      kc.SampleSize,
+			SampleMethod:    kc.SampleMethod,
+			TopN:            kc.TopN,
+			PrefixDelimiter: kc.PrefixDelimiter,
+			PrefixDepth:     kc.PrefixDepth,
+			PrefixRegex:     kc.PrefixRegex,
+			MaxPrefixes:     kc.MaxPrefixes,
+		}
+	}
+
+	return t, nil
+}
+
+// validateAddrs checks that every address of t can be parsed, the DNS
+// addresses aren't resolved.
+func (t *target) validateAddrs() error {
+	for _, addr := range t.addrs {
+		if isDNSAddr(addr) {
+			if err := validateDNSAddr(addr); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := t.newRedisOptions(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedAddrs returns the seed addresses of t, with the DNS addresses replaced
+// by the addresses they resolve to.
+func (t *target) seedAddrs(ctx context.Context) []string {
+	var addrs []string
+	for _, addr := range t.addrs {
+		if !isDNSAddr(addr) {
+			addrs = append(addrs, addr)
+			continue
+		}
+		resolved, err := dnsResolver.resolve(ctx, addr)
+		if err != nil {
+			level.Error(t.logger).Log("msg", "Error resolving DNS address", "name", addr, "err", err)
+			continue
+		}
+		addrs = append(addrs, resolved...)
+	}
+	return addrs
+}
+
+func (t *target) hasScraper(scraper collector.Scraper) bool {
+	for _, s := range t.scrapers {
+		if s == scraper {
+			return true
+		}
+	}
+	return false
+}
+
+// scrapersFor returns the scrapers of t for nodes in mode, cluster.info is
+// added for cluster nodes.
+func (t *target) scrapersFor(mode string) []collector.Scraper {
+	if mode != "cluster" || t.hasScraper(clusterInfoScraper) {
+		return t.scrapers
+	}
+	return append(append([]collector.Scraper{}, t.scrapers...), clusterInfoScraper)
+}
+
+// credentials returns the default username and password of the nodes of the
+// target, which fall back to the flags.
+func (t *target) credentials() (string, string) {
+	username := t.username
+	if username == "" {
+		username = *user
+	}
+
+	if t.passwdFile != nil {
+		content, _, err := t.passwdFile.read()
+		if err != nil {
+			level.Error(t.logger).Log("msg", "Error reading password file", "file", t.passwdFile.path, "err", err)
+		}
+		return username, strings.TrimSpace(string(content))
+	}
+	if t.password != "" {
+		return username, t.password
+	}
+	return username, creds.password()
+}
+
+// newRedisOptions returns the options of a target address, given as
+// `[user[:password]@]host:port`, a unix socket path, or a redis://, rediss://
+// or unix:// URI, which connect with TLS for rediss:// only. Credentials in the
+// address override the credentials file, which overrides the credentials of the
+// target.
+func (t *target) newRedisOptions(addr string) (*redis.Options, error) {
+	opt := &redis.Options{
+		Addr:      addr,
+		TLSConfig: t.tlsConfig,
+	}
+	hasUserinfo := false
+
+	switch {
+	case strings.Contains(addr, "://"):
+		u, err := url.Parse(addr)
+		if err != nil {
+			// The parse error quotes the URI, which may contain a password.
+			return nil, errors.New("invalid redis URI")
+		}
+		opt, err = redis.ParseURL(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis URI %s: %w", u.Redacted(), err)
+		}
+		hasUserinfo = u.User != nil
+		// The scheme decides on TLS: only rediss:// URIs get the TLS
+		// settings of the target.
+		if opt.TLSConfig != nil && t.tlsConfig != nil {
+			cfg := t.tlsConfig.Clone()
+			if cfg.ServerName == "" {
+				cfg.ServerName = opt.TLSConfig.ServerName
+			}
+			opt.TLSConfig = cfg
+		}
+	case strings.HasPrefix(addr, "/"):
+		opt.Network = "unix"
+	default:
+		if i := strings.LastIndex(addr, "@"); i >= 0 {
+			userinfo := addr[:i]
+			opt.Addr = addr[i+1:]
+			if j := strings.Index(userinfo, ":"); j >= 0 {
+				opt.Username, opt.Password = userinfo[:j], userinfo[j+1:]
+			} else {
+				opt.Username = userinfo
+			}
+			hasUserinfo = true
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(opt.Addr); err == nil && opt.Network != "unix" {
+		opt.TLSConfig = tlsConfigForHost(opt.TLSConfig, host)
+	}
+
+	opt.ClientName = *clientName
+	if !hasUserinfo {
+		if c, ok := creds.lookup(opt.Addr); ok {
+			opt.Username, opt.Password = c.Username, c.Password
+		} else {
+			opt.Username, opt.Password = t.credentials()
+		}
+	}
+
+	return opt, nil
+}
+
+// redactAddr returns addr without the password it may contain, for logs.
+func redactAddr(addr string) string {
+	if strings.Contains(addr, "://") {
+		if u, err := url.Parse(addr); err == nil {
+			return u.Redacted()
+		}
+		return "<invalid redis URI>"
+	}
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		userinfo := addr[:i]
+		if j := strings.Index(userinfo, ":"); j >= 0 {
+			userinfo = userinfo[:j] + ":xxxxx"
+		}
+		return userinfo + "@" + addr[i+1:]
+	}
+	return addr
+}
+
+// addrName returns the name of the node at addr without the credentials it may
+// contain: its host:port, or unix:// and the socket path. The DNS addresses and
+// the invalid ones are returned redacted.
+func addrName(addr string) string {
+	switch {
+	case isDNSAddr(addr):
+		return addr
+	case strings.Contains(addr, "://"):
+		if _, err := url.Parse(addr); err != nil {
+			return redactAddr(addr)
+		}
+		opt, err := redis.ParseURL(addr)
+		if err != nil {
+			return redactAddr(addr)
+		}
+		if opt.Network == "unix" {
+			return "unix://" + opt.Addr
+		}
+		return opt.Addr
+	case strings.HasPrefix(addr, "/"):
+		return "unix://" + addr
+	}
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return addr[i+1:]
+	}
+	return addr
+}
+
+// newNodeOptions returns the options of a node discovered from seed, which
+// shares the credentials of the seed unless the credentials file has some for it.
+func newNodeOptions(seed *redis.Options, addr string) *redis.Options {
+	opt := *seed
+	opt.Network = "tcp"
+	opt.Addr = addr
+	if c, ok := creds.lookup(addr); ok {
+		opt.Username, opt.Password = c.Username, c.Password
+	}
+	// Verify the node certificate against the node host when the server name
+	// comes from the seed host.
+	if host, _, err := net.SplitHostPort(seed.Addr); err == nil && opt.TLSConfig != nil && opt.TLSConfig.ServerName == host {
+		if nodeHost, _, err := net.SplitHostPort(addr); err == nil {
+			cfg := opt.TLSConfig.Clone()
+			cfg.ServerName = ""
+			opt.TLSConfig = tlsConfigForHost(cfg, nodeHost)
+		}
+	}
+	return &opt
+}
+
+// setNodes records the nodes found by a discovery, the previous ones are kept
+// if none was found. The state kept for the previous nodes which are gone is
+// dropped, unless another target still uses them.
+func (t *target) setNodes(nodes *redisNodes) {
+	if len(nodes.opts) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	previous := t.nodes
+	t.nodes, t.discovered = nodes, time.Now()
+	t.mu.Unlock()
+
+	if previous == nil {
+		return
+	}
+	found := make(map[string]bool, len(nodes.opts))
+	for _, opt := range nodes.opts {
+		found[opt.Addr] = true
+	}
+	var gone []string
+	for _, opt := range previous.opts {
+		if !found[opt.Addr] {
+			gone = append(gone, opt.Addr)
+		}
+	}
+	if len(gone) > 0 {
+		forgetNodes(gone, targets.addrsInUse(t))
+	}
+}
+
+// lastNodes returns the nodes of the last discovery if it is younger than
+// maxAge, nil otherwise.
+func (t *target) lastNodes(maxAge time.Duration) *redisNodes {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.nodes == nil || time.Since(t.discovered) >= maxAge {
+		return nil
+	}
+	return t.nodes
+}
+
+// nodeAddrs returns the addresses of the seeds and of the last discovered
+// nodes of t, which include the resolved DNS addresses.
+func (t *target) nodeAddrs() []string {
+	var addrs []string
+	for _, addr := range t.addrs {
+		if isDNSAddr(addr) {
+			continue
+		}
+		if opt, err := t.newRedisOptions(addr); err == nil {
+			addrs = append(addrs, opt.Addr)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.nodes != nil {
+		for _, opt := range t.nodes.opts {
+			addrs = append(addrs, opt.Addr)
+		}
+	}
+	return addrs
+}
+
+var targetsCount = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "redis_exporter",
+	Name:      "targets",
+	Help:      "Number of redis targets, from the flags, the config file and the targets file.",
+})
+
+// targetSet holds the current targets. The targets of the flags or the config
+// file are replaced as a whole on config reloads, those of the targets file
+// when it changes.
+type targetSet struct {
+	mu          sync.RWMutex
+	targets     []*target
+	fileTargets []*target
+}
+
+func (s *targetSet) get() []*target {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.fileTargets) == 0 {
+		return s.targets
+	}
+	return append(append([]*target{}, s.targets...), s.fileTargets...)
+}
+
+// names returns the names of the targets of the flags or the config file, and
+// those of the targets file.
+func (s *targetSet) names() (map[string]bool, map[string]bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	configured := make(map[string]bool, len(s.targets))
+	for _, t := range s.targets {
+		configured[t.name] = true
+	}
+	file := make(map[string]bool, len(s.fileTargets))
+	for _, t := range s.fileTargets {
+		file[t.name] = true
+	}
+	return configured, file
+}
+
+func (s *targetSet) set(targets []*target) {
+	s.mu.Lock()
+	old := s.targets
+	s.targets = targets
+	s.mu.Unlock()
+
+	s.forget(old)
+}
+
+func (s *targetSet) setFile(targets []*target) {
+	s.mu.Lock()
+	old := s.fileTargets
+	s.fileTargets = targets
+	s.mu.Unlock()
+
+	s.forget(old)
+}
+
+// forget drops the state kept for the nodes and the DNS addresses of the
+// removed targets which no current target uses anymore.
+func (s *targetSet) forget(removed []*target) {
+	current := s.get()
+	targetsCount.Set(float64(len(current)))
+
+	inUse := s.addrsInUse()
+	for _, t := range removed {
+		forgetNodes(t.nodeAddrs(), inUse)
+		for _, addr := range t.addrs {
+			if isDNSAddr(addr) && !inUse[addr] && dnsResolver != nil {
+				dnsResolver.forget(addr)
+			}
+		}
+	}
+}
+
+// addrsInUse returns the node and DNS addresses used by the current targets
+// and by the extra ones.
+func (s *targetSet) addrsInUse(extra ...*target) map[string]bool {
+	inUse := make(map[string]bool)
+	for _, t := range append(s.get(), extra...) {
+		for _, addr := range t.nodeAddrs() {
+			inUse[addr] = true
+		}
+		for _, addr := range t.addrs {
+			if isDNSAddr(addr) {
+				inUse[addr] = true
+			}
+		}
+	}
+	return inUse
+}
+
+// forgetNodes drops the state kept by the scheduler, the breaker and the
+// scrapers for the nodes at addrs which aren't in use.
+func forgetNodes(addrs []string, inUse map[string]bool) {
+	for _, addr := range addrs {
+		if inUse[addr] {
+			continue
+		}
+		if scheduler != nil {
+			scheduler.Forget(addr)
+		}
+		if breaker != nil {
+			breaker.Forget(addr)
+		}
+		for _, scraper := range scrapersByName {
+			if ns, ok := scraper.(collector.NodeStateScraper); ok {
+				ns.Forget(addr)
+			}
+		}
+	}
+}
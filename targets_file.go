@@ -0,0 +1,155 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/xieyanke/redis_exporter/collector"
+	"github.com/xieyanke/redis_exporter/config"
+	"gopkg.in/yaml.v3"
+)
+
+var targetsFileLoadErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "redis_exporter",
+	Name:      "targets_file_load_errors_total",
+	Help:      "Number of errors reading or parsing the targets file.",
+})
+
+// fileTargetGroup is an entry of the targets file, in the Prometheus file_sd
+// format.
+type fileTargetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// loadFileTargets returns the targets of the targets file, one per address
+// named after its node, labeled with the labels of its group. The labels starting
+// with `__` are dropped, as Prometheus does.
+func loadFileTargets(path string, content []byte, scrapers []collector.Scraper, logger log.Logger) ([]*target, error) {
+	var groups []fileTargetGroup
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(content, &groups)
+	} else {
+		err = yaml.Unmarshal(content, &groups)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing targets file %s: %w", path, err)
+	}
+
+	cfg := &config.Config{}
+	for _, g := range groups {
+		labels := make(map[string]string, len(g.Labels))
+		for name, value := range g.Labels {
+			if !strings.HasPrefix(name, "__") {
+				labels[name] = value
+			}
+		}
+		for _, addr := range g.Targets {
+			cfg.Targets = append(cfg.Targets, &config.Target{
+				Name:   addrName(addr),
+				Addrs:  []string{addr},
+				Mode:   *mode,
+				Labels: labels,
+			})
+		}
+	}
+	if len(cfg.Targets) == 0 {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid targets file %s: %w", path, err)
+	}
+
+	var ts []*target
+	for _, c := range cfg.Targets {
+		t, err := newConfigTarget(c, scrapers, logger)
+		if err != nil {
+			return nil, fmt.Errorf("target %q: %w", c.Name, err)
+		}
+		if err := t.validateAddrs(); err != nil {
+			return nil, fmt.Errorf("target %q: %w", c.Name, err)
+		}
+		t.labels = mergeLabels(*labels, c.Labels, map[string]string{"target": t.name})
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// reloadTargetsFile replaces the targets of the targets file if it changed
+// since the last load, they are kept if the new ones can't be loaded. The file
+// is loaded again on the next call until it succeeds.
+func reloadTargetsFile(f *watchedFile, scrapers []collector.Scraper, logger log.Logger) error {
+	content, modTime, changed, err := f.load()
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	ts, err := loadFileTargets(f.path, content, scrapers, logger)
+	if err != nil {
+		return err
+	}
+
+	configured, previous := targets.names()
+	for _, t := range ts {
+		if configured[t.name] {
+			return fmt.Errorf("target %q of the targets file is already configured", t.name)
+		}
+	}
+
+	targets.setFile(ts)
+	f.commit(content, modTime)
+	level.Info(logger).Log("msg", "Loaded targets file", "file", f.path, "targets", len(ts))
+
+	for _, t := range ts {
+		if !previous[t.name] {
+			checkACL(t, t.logger)
+		}
+	}
+	return nil
+}
+
+// watchTargetsFile reloads the targets file every interval until ctx is done.
+func watchTargetsFile(ctx context.Context, f *watchedFile, interval time.Duration, scrapers []collector.Scraper, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := reloadTargetsFile(f, scrapers, logger); err != nil {
+			targetsFileLoadErrors.Inc()
+			level.Error(logger).Log("msg", "Error loading targets file", "file", f.path, "err", err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// tlsFiles holds the client certificate and the CA bundle loaded from disk, and
+// loads them again whenever one of the files is modified.
+type tlsFiles struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	pool        *x509.CertPool
+	caModTime   time.Time
+}
+
+func fileModTime(paths ...string) (time.Time, error) {
+	var modTime time.Time
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+// certificate returns the client certificate, reloaded if the files have changed.
+func (f *tlsFiles) certificate() (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	modTime, err := fileModTime(f.certFile, f.keyFile)
+	if err != nil {
+		if f.cert != nil {
+			// Keep the current certificate while the files are being rotated.
+			return f.cert, nil
+		}
+		return nil, err
+	}
+	if f.cert != nil && modTime.Equal(f.certModTime) {
+		return f.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		if f.cert != nil {
+			return f.cert, nil
+		}
+		return nil, err
+	}
+	f.cert, f.certModTime = &cert, modTime
+
+	return f.cert, nil
+}
+
+// certPool returns the CA bundle, reloaded if the file has changed.
+func (f *tlsFiles) certPool() (*x509.CertPool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	modTime, err := fileModTime(f.caFile)
+	if err != nil {
+		if f.pool != nil {
+			return f.pool, nil
+		}
+		return nil, err
+	}
+	if f.pool != nil && modTime.Equal(f.caModTime) {
+		return f.pool, nil
+	}
+
+	pem, err := os.ReadFile(f.caFile)
+	if err != nil {
+		if f.pool != nil {
+			return f.pool, nil
+		}
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		if f.pool != nil {
+			return f.pool, nil
+		}
+		return nil, fmt.Errorf("no certificate found in %s", f.caFile)
+	}
+	f.pool, f.caModTime = pool, modTime
+
+	return f.pool, nil
+}
+
+// verifyConnection verifies the server certificate chain against the current
+// CA bundle, in place of the static RootCAs verification.
+func (f *tlsFiles) verifyConnection(cs tls.ConnectionState) error {
+	pool, err := f.certPool()
+	if err != nil {
+		return err
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no server certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// tlsConfigForHost returns a copy of cfg for the connections to host, whose
+// server certificate is verified against the configured server name, or host
+// when there is none. Go doesn't send IP hosts in SNI, so VerifyConnection
+// would see no server name for them and only verify the certificate chain.
+func tlsConfigForHost(cfg *tls.Config, host string) *tls.Config {
+	if cfg == nil {
+		return nil
+	}
+
+	c := cfg.Clone()
+	if c.ServerName == "" {
+		c.ServerName = host
+	}
+	if verify := cfg.VerifyConnection; verify != nil {
+		name := c.ServerName
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			// The name of the config derived last is kept, a node config
+			// is derived from the config of its seed.
+			if cs.ServerName == "" {
+				cs.ServerName = name
+			}
+			return verify(cs)
+		}
+	}
+	return c
+}
+
+// newTLSConfig returns the client TLS config shared by every redis connection.
+// The certificate and the CA bundle are read from disk again when they rotate.
+func newTLSConfig(certFile, keyFile, caFile, serverName, minVersion string, insecureSkipVerify bool) (*tls.Config, error) {
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q", minVersion)
+	}
+
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         version,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	files := &tlsFiles{certFile: certFile, keyFile: keyFile, caFile: caFile}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("both the TLS cert file and key file are required for client authentication")
+		}
+		if _, err := files.certificate(); err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return files.certificate()
+		}
+	}
+
+	if caFile != "" && !insecureSkipVerify {
+		if _, err := files.certPool(); err != nil {
+			return nil, err
+		}
+		// The chain is verified against the reloadable CA bundle in VerifyConnection.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = files.verifyConnection
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2023 XieYanke.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// writeCert writes a new self-signed CA certificate for dnsName and its key to
+// certFile and keyFile, modified at modTime, and returns the certificate.
+func writeCert(t *testing.T, certFile, keyFile, dnsName string, modTime time.Time) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(modTime.UnixNano()),
+		Subject:               pkix.Name{CommonName: dnsName},
+		DNSNames:              []string{dnsName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modTime)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), modTime)
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path string, content []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTLSFilesCertificate checks that the client certificate is loaded again
+// when its files change, and kept while they are invalid or missing.
+func TestTLSFilesCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	now := time.Now()
+	first := writeCert(t, certFile, keyFile, "client", now)
+
+	cfg, err := newTLSConfig(certFile, keyFile, "", "", "TLS12", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := func() *x509.Certificate {
+		t.Helper()
+		cert, err := cfg.GetClientCertificate(&tls.CertificateRequestInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	if !leaf().Equal(first) {
+		t.Error("first certificate not used")
+	}
+
+	second := writeCert(t, certFile, keyFile, "client", now.Add(time.Second))
+	if !leaf().Equal(second) {
+		t.Error("rotated certificate not loaded")
+	}
+
+	writeFile(t, keyFile, []byte("not a key"), now.Add(2*time.Second))
+	if !leaf().Equal(second) {
+		t.Error("certificate dropped while its key is invalid")
+	}
+
+	os.Remove(certFile)
+	if !leaf().Equal(second) {
+		t.Error("certificate dropped while its file is missing")
+	}
+}
+
+// TestTLSFilesCertPool checks that the server certificates are verified
+// against the CA bundle loaded again when it changes.
+func TestTLSFilesCertPool(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	now := time.Now()
+	first := writeCert(t, caFile, filepath.Join(dir, "first-key.pem"), "redis.internal", now)
+	second := writeCert(t, filepath.Join(dir, "second.pem"), filepath.Join(dir, "second-key.pem"), "redis.internal", now)
+
+	cfg, err := newTLSConfig("", "", caFile, "", "TLS12", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.InsecureSkipVerify || cfg.VerifyConnection == nil {
+		t.Fatal("the server certificate isn't verified against the reloadable CA bundle")
+	}
+	verify := func(cert *x509.Certificate, serverName string) error {
+		return cfg.VerifyConnection(tls.ConnectionState{ServerName: serverName, PeerCertificates: []*x509.Certificate{cert}})
+	}
+
+	if err := verify(first, "redis.internal"); err != nil {
+		t.Errorf("certificate of the CA rejected: %v", err)
+	}
+	if err := verify(first, "other.internal"); err == nil {
+		t.Error("certificate of another name accepted")
+	}
+	if err := verify(second, "redis.internal"); err == nil {
+		t.Error("certificate of an unknown CA accepted")
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "second.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, caFile, content, now.Add(time.Second))
+	if err := verify(second, "redis.internal"); err != nil {
+		t.Errorf("certificate of the rotated CA rejected: %v", err)
+	}
+	if err := verify(first, "redis.internal"); err == nil {
+		t.Error("certificate of the previous CA accepted")
+	}
+
+	writeFile(t, caFile, []byte("no certificate"), now.Add(2*time.Second))
+	if err := verify(second, "redis.internal"); err != nil {
+		t.Errorf("CA bundle dropped while it is invalid: %v", err)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writeCert(t, certFile, keyFile, "client", time.Now())
+
+	tests := []struct {
+		name                      string
+		certFile, keyFile, caFile string
+		minVersion                string
+		wantErr                   bool
+	}{
+		{name: "no files", minVersion: "TLS13"},
+		{name: "client certificate", certFile: certFile, keyFile: keyFile, minVersion: "TLS12"},
+		{name: "unknown version", minVersion: "SSL3", wantErr: true},
+		{name: "cert without key", certFile: certFile, minVersion: "TLS12", wantErr: true},
+		{name: "missing cert", certFile: filepath.Join(dir, "missing.pem"), keyFile: keyFile, minVersion: "TLS12", wantErr: true},
+		{name: "invalid CA", caFile: keyFile, minVersion: "TLS12", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := newTLSConfig(tt.certFile, tt.keyFile, tt.caFile, "redis.internal", tt.minVersion, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (cfg.MinVersion != tlsVersions[tt.minVersion] || cfg.ServerName != "redis.internal") {
+				t.Errorf("got min version %#x server name %q", cfg.MinVersion, cfg.ServerName)
+			}
+		})
+	}
+}
+
+// issueCert returns a server certificate for dnsNames and ips signed by the
+// CA certificate caFile and its key caKeyFile.
+func issueCert(t *testing.T, caFile, caKeyFile string, dnsNames []string, ips []net.IP) tls.Certificate {
+	t.Helper()
+
+	ca, err := tls.LoadX509KeyPair(caFile, caKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "redis"},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newTLSServer returns the address of a server presenting cert, which closes
+// every connection once the handshake is done.
+func newTLSServer(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// TestTLSServerName checks that the certificate of a node is verified against
+// the configured server name, or the node host, IP hosts included.
+func TestTLSServerName(t *testing.T) {
+	dir := t.TempDir()
+	caFile, caKeyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	writeCert(t, caFile, caKeyFile, "ca", time.Now())
+
+	localhost := net.ParseIP("127.0.0.1")
+	tests := []struct {
+		name       string
+		serverName string
+		dnsNames   []string
+		ips        []net.IP
+		// seed is the address of the seed the node is discovered from, the
+		// node is the seed when empty.
+		seed    string
+		wantErr bool
+	}{
+		{name: "ip", ips: []net.IP{localhost}},
+		{name: "other ip", ips: []net.IP{net.ParseIP("10.0.0.2")}, wantErr: true},
+		{name: "name of an ip", dnsNames: []string{"redis.internal"}, wantErr: true},
+		{name: "server name", serverName: "redis.internal", dnsNames: []string{"redis.internal"}},
+		{name: "other server name", serverName: "redis.internal", dnsNames: []string{"cache.internal"}, ips: []net.IP{localhost}, wantErr: true},
+		{name: "discovered node", seed: "127.0.0.2:6379", ips: []net.IP{localhost}},
+		{name: "discovered node with the seed certificate", seed: "127.0.0.2:6379", ips: []net.IP{net.ParseIP("127.0.0.2")}, wantErr: true},
+		{name: "discovered node with server name", serverName: "redis.internal", seed: "127.0.0.2:6379", dnsNames: []string{"redis.internal"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := newTLSServer(t, issueCert(t, caFile, caKeyFile, tt.dnsNames, tt.ips))
+			cfg, err := newTLSConfig("", "", caFile, tt.serverName, "TLS12", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tg := &target{tlsConfig: cfg, logger: log.NewNopLogger()}
+
+			var opt *redis.Options
+			if tt.seed == "" {
+				opt, err = tg.newRedisOptions(addr)
+			} else {
+				var seed *redis.Options
+				seed, err = tg.newRedisOptions(tt.seed)
+				opt = newNodeOptions(seed, addr)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn, err := redis.NewDialer(opt)(context.Background(), "tcp", opt.Addr)
+			if err == nil {
+				conn.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}